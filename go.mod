@@ -7,14 +7,14 @@ require (
 	github.com/apex/log v1.8.0
 	github.com/blang/semver/v4 v4.0.0
 	github.com/go-ozzo/ozzo-validation/v4 v4.3.0
-	github.com/gorilla/context v1.1.1
-	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/schema v1.2.0
+	github.com/gorilla/securecookie v1.1.1
 	github.com/kr/text v0.2.0 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/spf13/cast v1.3.1
 	github.com/stoewer/go-strcase v1.2.0
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
 )
@@ -0,0 +1,398 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+type (
+	// APIKey is the stored metadata for an api key, the key itself is never stored, only its hash
+	APIKey struct {
+		// Prefix is the public key identifier, keys have the form <prefix>.<secret>
+		Prefix string `json:"prefix"`
+
+		// Hash is the hex encoded sha256 hash of the full key
+		Hash string `json:"hash"`
+
+		// Subject is the principal subject for the key
+		Subject string `json:"subject"`
+
+		// Scopes are the scopes granted to the key
+		Scopes []string `json:"scopes,omitempty"`
+
+		// Roles are the roles granted to the key
+		Roles []string `json:"roles,omitempty"`
+
+		// ExpiresAt is the optional key expiration
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+		// Metadata is additional key metadata added to the principal attributes
+		Metadata map[string]interface{} `json:"metadata,omitempty"`
+	}
+
+	// KeyStore looks up api keys by prefix
+	KeyStore interface {
+		// Key returns the key for the prefix or an error wrapping ErrKeyNotFound
+		Key(ctx context.Context, prefix string) (*APIKey, error)
+	}
+
+	// MemoryKeyStore is an in-memory KeyStore
+	MemoryKeyStore struct {
+		keys map[string]*APIKey
+		lock sync.RWMutex
+	}
+
+	// FileKeyStore is a KeyStore backed by a json file containing an array of APIKey objects,
+	// the file is reloaded when it changes and the last good keys are kept if a reload fails
+	FileKeyStore struct {
+		path    string
+		mod     time.Time
+		checked time.Time
+		store   *MemoryKeyStore
+		log     log.Interface
+		lock    sync.Mutex
+	}
+
+	// APIKeyOption defines api key authorizer options
+	APIKeyOption func(*apiKeyAuthorizer)
+
+	apiKeyAuthorizer struct {
+		store  KeyStore
+		header string
+		query  string
+		basic  bool
+		realm  string
+		log    log.Interface
+		now    func() time.Time
+	}
+)
+
+const (
+	apiKeySeparator = "."
+
+	defaultAPIKeyHeader = "X-API-Key"
+
+	fileKeyStoreCheckInterval = time.Second * 5
+)
+
+var (
+	// ErrAPIKeyMissing is returned when the request has no api key
+	ErrAPIKeyMissing = fmt.Errorf("%w: api key", ErrCredentialsMissing)
+
+	// ErrAPIKeyInvalid is returned when the api key does not match a stored key
+	ErrAPIKeyInvalid = errors.New("api key invalid")
+
+	// ErrAPIKeyExpired is returned when the api key has expired
+	ErrAPIKeyExpired = errors.New("api key expired")
+
+	// ErrAPIKeyUnavailable is returned when the key store fails, the store error is logged
+	ErrAPIKeyUnavailable = errors.New("api key verification unavailable")
+)
+
+// APIKeyAuthorizer returns an Authorizer that validates api keys against the store and adds
+// the key as the request Principal; by default the key is read from the X-API-Key header
+func APIKeyAuthorizer(store KeyStore, opts ...APIKeyOption) Authorizer {
+	a := &apiKeyAuthorizer{
+		store:  store,
+		header: defaultAPIKeyHeader,
+		realm:  defaultAuthRealm,
+		log:    log.Log,
+		now:    time.Now,
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a.authorize
+}
+
+func (a *apiKeyAuthorizer) authorize(r *http.Request) (context.Context, error) {
+	key := a.extract(r)
+	if key == "" {
+		return nil, a.error(ErrAPIKeyMissing)
+	}
+
+	prefix := APIKeyPrefix(key)
+	if prefix == "" {
+		return nil, a.error(ErrAPIKeyInvalid)
+	}
+
+	k, err := a.store.Key(r.Context(), prefix)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, a.error(ErrAPIKeyInvalid)
+		}
+
+		// store errors may contain internal details and are not returned to the client
+		a.log.Errorf("api key store: %s", err)
+
+		return nil, NewAuthError(http.StatusInternalServerError, "", ErrAPIKeyUnavailable)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(strings.ToLower(k.Hash))) != 1 {
+		return nil, a.error(ErrAPIKeyInvalid)
+	}
+
+	if k.ExpiresAt != nil && a.now().After(*k.ExpiresAt) {
+		return nil, a.error(ErrAPIKeyExpired)
+	}
+
+	attrs := map[string]interface{}{
+		"key_prefix": k.Prefix,
+	}
+
+	for key, val := range k.Metadata {
+		attrs[key] = val
+	}
+
+	subject := k.Subject
+	if subject == "" {
+		subject = k.Prefix
+	}
+
+	return ContextWithPrincipal(r.Context(), &Principal{
+		Subject:    subject,
+		Type:       "apikey",
+		Scopes:     k.Scopes,
+		Roles:      k.Roles,
+		Attributes: attrs,
+	}), nil
+}
+
+func (a *apiKeyAuthorizer) extract(r *http.Request) string {
+	if a.header != "" {
+		if key := r.Header.Get(a.header); key != "" {
+			return key
+		}
+	}
+
+	if a.query != "" {
+		if key := r.URL.Query().Get(a.query); key != "" {
+			return key
+		}
+	}
+
+	if a.basic {
+		if user, pass, ok := r.BasicAuth(); ok {
+			if pass != "" {
+				return pass
+			}
+			return user
+		}
+	}
+
+	return ""
+}
+
+func (a *apiKeyAuthorizer) error(err error) error {
+	scheme := "ApiKey"
+	if a.basic {
+		scheme = "Basic"
+	}
+
+	return NewAuthError(http.StatusUnauthorized, authChallenge(scheme, map[string]string{"realm": a.realm}), err)
+}
+
+// GenerateAPIKey generates a new random api key with the prefix, returning the key to give to the
+// client and the APIKey to store; if prefix is empty a random prefix is generated
+func GenerateAPIKey(prefix string) (string, *APIKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	if prefix == "" {
+		id := make([]byte, 6)
+		if _, err := rand.Read(id); err != nil {
+			return "", nil, err
+		}
+		prefix = hex.EncodeToString(id)
+	}
+
+	if strings.Contains(prefix, apiKeySeparator) {
+		return "", nil, fmt.Errorf("api key prefix must not contain %q", apiKeySeparator)
+	}
+
+	key := prefix + apiKeySeparator + base64.RawURLEncoding.EncodeToString(secret)
+
+	return key, &APIKey{
+		Prefix: prefix,
+		Hash:   HashAPIKey(key),
+	}, nil
+}
+
+// HashAPIKey returns the hex encoded sha256 hash of the key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyPrefix returns the identifying prefix of the key
+func APIKeyPrefix(key string) string {
+	if i := strings.Index(key, apiKeySeparator); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// NewMemoryKeyStore returns a new in-memory KeyStore with the keys
+func NewMemoryKeyStore(keys ...*APIKey) *MemoryKeyStore {
+	s := &MemoryKeyStore{
+		keys: make(map[string]*APIKey),
+	}
+
+	for _, k := range keys {
+		s.Add(k)
+	}
+
+	return s
+}
+
+// Add adds or replaces a key
+func (s *MemoryKeyStore) Add(k *APIKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.keys[k.Prefix] = k
+}
+
+// Remove removes the key with the prefix
+func (s *MemoryKeyStore) Remove(prefix string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.keys, prefix)
+}
+
+// Key implements the KeyStore interface
+func (s *MemoryKeyStore) Key(_ context.Context, prefix string) (*APIKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if k, ok := s.keys[prefix]; ok {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, prefix)
+}
+
+// NewFileKeyStore returns a new KeyStore loaded from the json file
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{
+		path: path,
+		log:  log.Log,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileKeyStore) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*APIKey, 0)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	s.store = NewMemoryKeyStore(keys...)
+	s.mod = info.ModTime()
+	s.checked = time.Now()
+
+	return nil
+}
+
+// Key implements the KeyStore interface, reloading the file if it has changed; if the reload
+// fails, i.e. the file is partially written, the error is logged and the previous keys are used
+func (s *FileKeyStore) Key(ctx context.Context, prefix string) (*APIKey, error) {
+	s.lock.Lock()
+
+	if time.Since(s.checked) > fileKeyStoreCheckInterval {
+		s.checked = time.Now()
+
+		if info, err := os.Stat(s.path); err == nil && !info.ModTime().Equal(s.mod) {
+			if err := s.load(); err != nil {
+				s.log.Errorf("api key store reload failed: %s", err)
+			}
+		}
+	}
+
+	store := s.store
+
+	s.lock.Unlock()
+
+	return store.Key(ctx, prefix)
+}
+
+// WithAPIKeyHeader sets the header the key is read from, an empty header disables it
+func WithAPIKeyHeader(header string) APIKeyOption {
+	return func(a *apiKeyAuthorizer) {
+		a.header = header
+	}
+}
+
+// WithAPIKeyQuery enables reading the key from the query parameter
+func WithAPIKeyQuery(param string) APIKeyOption {
+	return func(a *apiKeyAuthorizer) {
+		a.query = param
+	}
+}
+
+// WithAPIKeyBasicAuth enables reading the key from the basic auth password, or username if the
+// password is empty
+func WithAPIKeyBasicAuth() APIKeyOption {
+	return func(a *apiKeyAuthorizer) {
+		a.basic = true
+	}
+}
+
+// WithAPIKeyLog sets the logger for key store errors
+func WithAPIKeyLog(l log.Interface) APIKeyOption {
+	return func(a *apiKeyAuthorizer) {
+		if l != nil {
+			a.log = l
+		}
+	}
+}
+
+// WithAPIKeyRealm sets the realm for the WWW-Authenticate challenge
+func WithAPIKeyRealm(realm string) APIKeyOption {
+	return func(a *apiKeyAuthorizer) {
+		a.realm = realm
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+type errKeyStore struct {
+	err error
+}
+
+func (s errKeyStore) Key(context.Context, string) (*APIKey, error) {
+	return nil, s.err
+}
+
+func TestAPIKeyAuthorizer(t *testing.T) {
+	key, stored, err := GenerateAPIKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Subject = "service"
+	stored.Scopes = []string{"read"}
+
+	expiredKey, expired, err := GenerateAPIKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	expired.ExpiresAt = &past
+
+	store := NewMemoryKeyStore(stored, expired)
+	quiet := WithAPIKeyLog(&log.Logger{Handler: discard.Default})
+
+	tests := []struct {
+		name        string
+		store       KeyStore
+		opts        []APIKeyOption
+		setup       func(r *http.Request)
+		wantErr     error
+		wantStatus  int
+		wantSubject string
+		notInError  string
+	}{
+		{
+			name:        "header",
+			store:       store,
+			setup:       func(r *http.Request) { r.Header.Set("X-API-Key", key) },
+			wantSubject: "service",
+		},
+		{
+			name:        "query",
+			store:       store,
+			opts:        []APIKeyOption{WithAPIKeyQuery("api_key")},
+			setup:       func(r *http.Request) { r.URL.RawQuery = "api_key=" + key },
+			wantSubject: "service",
+		},
+		{
+			name:        "basic auth",
+			store:       store,
+			opts:        []APIKeyOption{WithAPIKeyBasicAuth()},
+			setup:       func(r *http.Request) { r.SetBasicAuth("", key) },
+			wantSubject: "service",
+		},
+		{
+			name:       "missing",
+			store:      store,
+			setup:      func(r *http.Request) {},
+			wantErr:    ErrCredentialsMissing,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "no prefix",
+			store:      store,
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", "secret") },
+			wantErr:    ErrAPIKeyInvalid,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown prefix",
+			store:      store,
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", "other.secret") },
+			wantErr:    ErrAPIKeyInvalid,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong secret",
+			store:      store,
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", "test.secret") },
+			wantErr:    ErrAPIKeyInvalid,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "expired",
+			store:      store,
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", expiredKey) },
+			wantErr:    ErrAPIKeyExpired,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "store error",
+			store:      errKeyStore{errors.New("invalid character in /etc/api/keys.json")},
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", key) },
+			wantErr:    ErrAPIKeyUnavailable,
+			wantStatus: http.StatusInternalServerError,
+			notInError: "/etc/api",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			tt.setup(r)
+
+			ctx, err := APIKeyAuthorizer(tt.store, append(tt.opts, quiet)...)(r)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+
+				var ae *AuthError
+				if !errors.As(err, &ae) || ae.Status() != tt.wantStatus {
+					t.Errorf("error = %#v, want status %d", err, tt.wantStatus)
+				}
+
+				if tt.notInError != "" && strings.Contains(err.Error(), tt.notInError) {
+					t.Errorf("error %q exposes %q", err, tt.notInError)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := RequestPrincipal(ctx)
+			if p == nil || p.Subject != tt.wantSubject || !p.HasScope("read") {
+				t.Errorf("principal = %+v", p)
+			}
+		})
+	}
+}
+
+func TestFileKeyStoreReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+	modTime := time.Now().Add(-time.Hour)
+
+	write := func(data []byte) {
+		t.Helper()
+
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		modTime = modTime.Add(time.Minute)
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := func(prefixes ...string) []byte {
+		list := make([]*APIKey, 0)
+		for _, p := range prefixes {
+			list = append(list, &APIKey{Prefix: p})
+		}
+		data, _ := json.Marshal(list)
+		return data
+	}
+
+	write(keys("first"))
+
+	s, err := NewFileKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.log = &log.Logger{Handler: discard.Default}
+
+	steps := []struct {
+		name    string
+		data    []byte
+		found   []string
+		missing []string
+	}{
+		{
+			name:    "replaced",
+			data:    keys("second"),
+			found:   []string{"second"},
+			missing: []string{"first"},
+		},
+		{
+			name:  "partial write keeps previous keys",
+			data:  []byte(`[{"prefix":"thi`),
+			found: []string{"second"},
+		},
+		{
+			name:    "fixed",
+			data:    keys("third"),
+			found:   []string{"third"},
+			missing: []string{"second"},
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			write(step.data)
+
+			// force the file check
+			s.lock.Lock()
+			s.checked = time.Time{}
+			s.lock.Unlock()
+
+			for _, p := range step.found {
+				if _, err := s.Key(context.Background(), p); err != nil {
+					t.Errorf("Key(%s) = %v", p, err)
+				}
+			}
+
+			for _, p := range step.missing {
+				if _, err := s.Key(context.Background(), p); !errors.Is(err, ErrKeyNotFound) {
+					t.Errorf("Key(%s) = %v, want %v", p, err, ErrKeyNotFound)
+				}
+			}
+		})
+	}
+
+	if _, err := NewFileKeyStore(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
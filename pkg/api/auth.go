@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type (
+	// Principal is the authenticated identity of a request, authorizers place the principal
+	// in the context using ContextWithPrincipal
+	Principal struct {
+		// Subject is the unique identifier of the principal
+		Subject string `json:"sub"`
+
+		// Type is the authentication type, i.e. jwt
+		Type string `json:"type,omitempty"`
+
+		// Scopes are the granted oauth scopes
+		Scopes []string `json:"scopes,omitempty"`
+
+		// Roles are the principal roles
+		Roles []string `json:"roles,omitempty"`
+
+		// Permissions are the principal permissions
+		Permissions []string `json:"permissions,omitempty"`
+
+		// Attributes are additional authorizer specific attributes
+		Attributes map[string]interface{} `json:"attributes,omitempty"`
+	}
+
+	// AuthError is an authorization error that renders as a Responder with the status
+	// and WWW-Authenticate challenges
+	AuthError struct {
+		status     int
+		challenges []string
+		err        error
+	}
+)
+
+var (
+	// ErrCredentialsMissing is wrapped by authorizer errors when the request has no credentials,
+	// Optional authorizers allow these requests to proceed anonymously
+	ErrCredentialsMissing = errors.New("credentials missing")
+
+	// ErrUnauthenticated is returned when a route requires a principal and none is present
+	ErrUnauthenticated = errors.New("authentication required")
+
+	// ErrForbidden is returned when the principal does not satisfy the route requirements
+	ErrForbidden = errors.New("insufficient privileges")
+
+	contextKeyPrincipal = contextKey("principal")
+
+	challengeEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// ContextWithPrincipal returns a new context with the principal
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKeyPrincipal, p)
+}
+
+// RequestPrincipal returns the authenticated principal from the context, or nil
+func RequestPrincipal(ctx context.Context) *Principal {
+	if p, ok := ctx.Value(contextKeyPrincipal).(*Principal); ok {
+		return p
+	}
+	return nil
+}
+
+// HasScope returns true if the principal has the scope
+func (p *Principal) HasScope(scope string) bool {
+	return stringsContain(p.Scopes, scope)
+}
+
+// HasRole returns true if the principal has the role
+func (p *Principal) HasRole(role string) bool {
+	return stringsContain(p.Roles, role)
+}
+
+// HasPermission returns true if the principal has the permission
+func (p *Principal) HasPermission(perm string) bool {
+	return stringsContain(p.Permissions, perm)
+}
+
+// NewAuthError returns a new authorization error, the challenges are set as the
+// WWW-Authenticate headers
+func NewAuthError(status int, challenge string, err error) *AuthError {
+	e := &AuthError{
+		status: status,
+		err:    err,
+	}
+
+	if challenge != "" {
+		e.challenges = []string{challenge}
+	}
+
+	return e
+}
+
+// Error implements the error interface
+func (e *AuthError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *AuthError) Unwrap() error {
+	return e.err
+}
+
+// Challenges returns the WWW-Authenticate challenges
+func (e *AuthError) Challenges() []string {
+	return e.challenges
+}
+
+// Status returns the http status
+func (e *AuthError) Status() int {
+	return e.status
+}
+
+// Payload returns the error payload
+func (e *AuthError) Payload() interface{} {
+	return struct {
+		Message string `json:"message"`
+	}{
+		Message: e.err.Error(),
+	}
+}
+
+// Write writes the error response
+func (e *AuthError) Write(w http.ResponseWriter, r *http.Request) error {
+	resp := NewResponse(e.Payload()).WithStatus(e.status)
+
+	for _, c := range e.challenges {
+		resp.header.Add("WWW-Authenticate", c)
+	}
+
+	return resp.Write(w, r)
+}
+
+// authChallenge formats a WWW-Authenticate challenge for the scheme and parameters
+func authChallenge(scheme string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+
+	// realm is conventionally first
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i] == "realm" || keys[j] == "realm" {
+			return keys[i] == "realm"
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, k, challengeEscaper.Replace(params[k])))
+	}
+
+	if len(parts) == 0 {
+		return scheme
+	}
+
+	return scheme + " " + strings.Join(parts, ", ")
+}
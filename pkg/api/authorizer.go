@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type (
+	// missingCredentials aggregates errors from authorizers that were all missing credentials
+	missingCredentials struct {
+		MultiError
+	}
+)
+
+// AllOf returns an Authorizer that requires all of the authorizers to succeed, they are called
+// in order and each receives the context of the previous; nil authorizers are ignored and nil
+// is returned if there are none
+func AllOf(auths ...Authorizer) Authorizer {
+	auths = compactAuthorizers(auths)
+
+	switch len(auths) {
+	case 0:
+		return nil
+	case 1:
+		return auths[0]
+	}
+
+	return func(r *http.Request) (context.Context, error) {
+		var authCtx context.Context
+
+		for _, a := range auths {
+			ctx, err := a(r)
+			if err != nil {
+				return nil, err
+			}
+
+			if ctx != nil {
+				r = r.WithContext(ctx)
+				authCtx = ctx
+			}
+		}
+
+		return authCtx, nil
+	}
+}
+
+// AnyOf returns an Authorizer that succeeds with the context of the first authorizer to succeed;
+// if all fail the errors are aggregated into a single AuthError with all of the challenges
+func AnyOf(auths ...Authorizer) Authorizer {
+	auths = compactAuthorizers(auths)
+
+	if len(auths) == 0 {
+		return nil
+	}
+
+	return func(r *http.Request) (context.Context, error) {
+		errs := make(MultiError, 0, len(auths))
+
+		for _, a := range auths {
+			ctx, err := a(r)
+			if err == nil {
+				return ctx, nil
+			}
+
+			errs = append(errs, err)
+		}
+
+		return nil, combineAuthErrors(errs)
+	}
+}
+
+// Optional returns an Authorizer that allows requests without credentials to proceed
+// anonymously, requests with invalid credentials still fail
+func Optional(a Authorizer) Authorizer {
+	if a == nil {
+		return nil
+	}
+
+	return func(r *http.Request) (context.Context, error) {
+		ctx, err := a(r)
+		if err != nil && errors.Is(err, ErrCredentialsMissing) {
+			return nil, nil
+		}
+		return ctx, err
+	}
+}
+
+func compactAuthorizers(auths []Authorizer) []Authorizer {
+	rval := make([]Authorizer, 0, len(auths))
+
+	for _, a := range auths {
+		if a != nil {
+			rval = append(rval, a)
+		}
+	}
+
+	return rval
+}
+
+// combineAuthErrors aggregates the errors from multiple authorizers, the status is taken from
+// the first authorizer that was presented credentials, or 401 if none were
+func combineAuthErrors(errs MultiError) error {
+	var status int
+	var challenges []string
+
+	for _, err := range errs {
+		var ae *AuthError
+
+		missing := errors.Is(err, ErrCredentialsMissing)
+
+		if errors.As(err, &ae) {
+			challenges = append(challenges, ae.challenges...)
+
+			if status == 0 && !missing {
+				status = ae.status
+			}
+		} else if status == 0 && !missing {
+			status = http.StatusUnauthorized
+		}
+	}
+
+	if status == 0 {
+		return &AuthError{
+			status:     http.StatusUnauthorized,
+			challenges: challenges,
+			err:        missingCredentials{errs},
+		}
+	}
+
+	return &AuthError{
+		status:     status,
+		challenges: challenges,
+		err:        errs,
+	}
+}
+
+// Unwrap returns ErrCredentialsMissing
+func (m missingCredentials) Unwrap() error {
+	return ErrCredentialsMissing
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type testAuthKey string
+
+// testAuthorizer adds its name to the context or fails with the error
+func testAuthorizer(name string, err error) Authorizer {
+	return func(r *http.Request) (context.Context, error) {
+		if err != nil {
+			return nil, err
+		}
+
+		names, _ := r.Context().Value(testAuthKey("names")).([]string)
+
+		return context.WithValue(r.Context(), testAuthKey("names"), append(names, name)), nil
+	}
+}
+
+func TestAuthorizerCombinators(t *testing.T) {
+	missing := NewAuthError(http.StatusUnauthorized, `Bearer realm="api"`, ErrTokenMissing)
+	missingKey := NewAuthError(http.StatusUnauthorized, `ApiKey realm="api"`, ErrAPIKeyMissing)
+	invalid := NewAuthError(http.StatusUnauthorized, `Bearer error="invalid_token"`, ErrTokenExpired)
+	forbidden := NewAuthError(http.StatusForbidden, "", ErrForbidden)
+
+	tests := []struct {
+		name           string
+		auth           Authorizer
+		wantNames      []string
+		wantErr        error
+		wantStatus     int
+		wantChallenges []string
+	}{
+		{
+			name: "no authorizers",
+			auth: AllOf(nil, nil),
+		},
+		{
+			name:      "all of",
+			auth:      AllOf(testAuthorizer("a", nil), testAuthorizer("b", nil)),
+			wantNames: []string{"a", "b"},
+		},
+		{
+			name:       "all of fails",
+			auth:       AllOf(testAuthorizer("a", nil), testAuthorizer("b", forbidden)),
+			wantErr:    ErrForbidden,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:      "any of first success",
+			auth:      AnyOf(testAuthorizer("a", missing), testAuthorizer("b", nil), testAuthorizer("c", nil)),
+			wantNames: []string{"b"},
+		},
+		{
+			name:           "any of all missing",
+			auth:           AnyOf(testAuthorizer("a", missing), testAuthorizer("b", missingKey)),
+			wantErr:        ErrCredentialsMissing,
+			wantStatus:     http.StatusUnauthorized,
+			wantChallenges: []string{`Bearer realm="api"`, `ApiKey realm="api"`},
+		},
+		{
+			name:           "any of presented credentials status wins",
+			auth:           AnyOf(testAuthorizer("a", missingKey), testAuthorizer("b", forbidden), testAuthorizer("c", invalid)),
+			wantStatus:     http.StatusForbidden,
+			wantChallenges: []string{`ApiKey realm="api"`, `Bearer error="invalid_token"`},
+		},
+		{
+			name:       "any of plain error",
+			auth:       AnyOf(testAuthorizer("a", errors.New("failed"))),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "optional without credentials",
+			auth: Optional(testAuthorizer("a", missing)),
+		},
+		{
+			name:       "optional with invalid credentials",
+			auth:       Optional(testAuthorizer("a", invalid)),
+			wantErr:    ErrTokenExpired,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:      "optional any of",
+			auth:      Optional(AnyOf(testAuthorizer("a", missing), testAuthorizer("b", missingKey))),
+			wantNames: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.auth == nil {
+				return
+			}
+
+			ctx, err := tt.auth(httptest.NewRequest("GET", "/", nil))
+
+			if tt.wantStatus != 0 {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+
+				var ae *AuthError
+				if errors.As(err, &ae) {
+					if ae.Status() != tt.wantStatus {
+						t.Errorf("status = %d, want %d", ae.Status(), tt.wantStatus)
+					}
+					if tt.wantChallenges != nil && !reflect.DeepEqual(ae.Challenges(), tt.wantChallenges) {
+						t.Errorf("challenges = %v, want %v", ae.Challenges(), tt.wantChallenges)
+					}
+				} else if tt.wantStatus != http.StatusUnauthorized {
+					t.Errorf("error = %v, want an AuthError", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			if ctx != nil {
+				names, _ = ctx.Value(testAuthKey("names")).([]string)
+			}
+
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestAuthErrorResponse(t *testing.T) {
+	err := AnyOf(
+		testAuthorizer("a", NewAuthError(http.StatusUnauthorized, `Bearer realm="api"`, ErrTokenMissing)),
+		testAuthorizer("b", NewAuthError(http.StatusUnauthorized, `Basic realm="api"`, ErrBasicAuthMissing)),
+	)
+
+	s := NewServer(WithBasepath("/"))
+
+	s.AddRoute("/test", func(ctx context.Context) Responder {
+		return NewResponse("ok")
+	}, WithAuthorizers(err))
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	if got := fmt.Sprint(rec.Header().Values("WWW-Authenticate")); got != `[Bearer realm="api" Basic realm="api"]` {
+		t.Errorf("challenges = %s", got)
+	}
+}
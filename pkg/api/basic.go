@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type (
+	// BasicVerifier verifies a username and password, returning the principal on success; a nil
+	// principal with a nil error uses the username as the principal subject
+	BasicVerifier func(ctx context.Context, username, password string) (*Principal, error)
+
+	// BasicOption defines basic authorizer options
+	BasicOption func(*basicAuthorizer)
+
+	basicAuthorizer struct {
+		verify BasicVerifier
+		realm  string
+	}
+)
+
+var (
+	// ErrBasicAuthMissing is returned when the request has no basic credentials
+	ErrBasicAuthMissing = fmt.Errorf("%w: basic auth", ErrCredentialsMissing)
+
+	// ErrInvalidCredentials is returned when the username or password is incorrect
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// BasicAuthorizer returns an Authorizer that verifies HTTP Basic credentials
+func BasicAuthorizer(verify BasicVerifier, opts ...BasicOption) Authorizer {
+	a := &basicAuthorizer{
+		verify: verify,
+		realm:  defaultAuthRealm,
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a.authorize
+}
+
+func (a *basicAuthorizer) authorize(r *http.Request) (context.Context, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, a.error(ErrBasicAuthMissing)
+	}
+
+	p, err := a.verify(r.Context(), user, pass)
+	if err != nil {
+		return nil, a.error(err)
+	}
+
+	if p == nil {
+		p = &Principal{
+			Subject: user,
+		}
+	}
+
+	if p.Type == "" {
+		p.Type = "basic"
+	}
+
+	return ContextWithPrincipal(r.Context(), p), nil
+}
+
+func (a *basicAuthorizer) error(err error) error {
+	return NewAuthError(
+		http.StatusUnauthorized,
+		authChallenge("Basic", map[string]string{
+			"realm":   a.realm,
+			"charset": "UTF-8",
+		}),
+		err)
+}
+
+// StaticCredentials returns a BasicVerifier for a fixed map of usernames to passwords, the
+// comparison is constant time
+func StaticCredentials(users map[string]string) BasicVerifier {
+	return func(_ context.Context, username, password string) (*Principal, error) {
+		expected, ok := users[username]
+		if !ok {
+			// compare anyway so unknown users take the same time
+			expected = password + "\x00"
+		}
+
+		if !SecureCompare(password, expected) || !ok {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, nil
+	}
+}
+
+// SecureCompare compares two strings in constant time, the strings are hashed first so the
+// comparison does not leak their lengths
+func SecureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// WithBasicRealm sets the realm for the WWW-Authenticate challenge
+func WithBasicRealm(realm string) BasicOption {
+	return func(a *basicAuthorizer) {
+		a.realm = realm
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuthorizer(t *testing.T) {
+	auth := BasicAuthorizer(StaticCredentials(map[string]string{"user": "pass"}), WithBasicRealm("test"))
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		noAuth   bool
+		wantErr  error
+	}{
+		{name: "valid", user: "user", password: "pass"},
+		{name: "missing", noAuth: true, wantErr: ErrCredentialsMissing},
+		{name: "wrong password", user: "user", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "unknown user", user: "other", password: "pass", wantErr: ErrInvalidCredentials},
+		{name: "password prefix", user: "user", password: "pas", wantErr: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if !tt.noAuth {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+
+			ctx, err := auth(r)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+
+				var ae *AuthError
+				if !errors.As(err, &ae) || ae.Challenges()[0] != `Basic realm="test", charset="UTF-8"` {
+					t.Errorf("error = %#v, want a Basic challenge", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p := RequestPrincipal(ctx); p == nil || p.Subject != tt.user || p.Type != "basic" {
+				t.Errorf("principal = %+v", p)
+			}
+		})
+	}
+}
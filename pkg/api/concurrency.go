@@ -0,0 +1,366 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Priority is a route priority class used when the server is overloaded
+	Priority int
+
+	// ConcurrencyLimiter limits the number of concurrently executing handlers, requests over the
+	// limit wait in a priority ordered queue until a slot is available or the queue timeout passes
+	ConcurrencyLimiter struct {
+		limit      float64
+		inflight   int
+		queue      []*concurrencyWaiter
+		queueSize  int
+		timeout    time.Duration
+		retryAfter time.Duration
+		algorithm  limitAlgorithm
+		lock       sync.Mutex
+	}
+
+	// ConcurrencyOption defines concurrency limiter options
+	ConcurrencyOption func(*ConcurrencyLimiter)
+
+	concurrencyWaiter struct {
+		priority Priority
+		ready    chan struct{}
+	}
+
+	// limitAlgorithm computes the new limit from a latency sample
+	limitAlgorithm interface {
+		update(limit float64, rtt time.Duration, inflight int) float64
+	}
+
+	// aimdLimit increases the limit additively while latency is below the threshold and
+	// decreases it multiplicatively when it is exceeded
+	aimdLimit struct {
+		min       float64
+		max       float64
+		threshold time.Duration
+		backoff   float64
+	}
+
+	// gradientLimit adjusts the limit by the gradient between the long term and recent latency,
+	// growing while latency is stable and shrinking as queueing inflates it
+	gradientLimit struct {
+		min   float64
+		max   float64
+		long  float64
+		short float64
+	}
+)
+
+const (
+	// PriorityLow requests are never queued and are shed first
+	PriorityLow Priority = iota - 1
+
+	// PriorityNormal is the default route priority
+	PriorityNormal
+
+	// PriorityHigh requests are queued ahead of normal requests
+	PriorityHigh
+
+	// PriorityCritical requests bypass concurrency limits, i.e. health checks and admin routes
+	PriorityCritical
+)
+
+const (
+	defaultConcurrencyRetryAfter = time.Second
+
+	gradientLongAlpha  = 0.01
+	gradientShortAlpha = 0.1
+	gradientSmoothing  = 0.2
+)
+
+var (
+	// ErrOverloaded is returned when a request is shed because the server is overloaded
+	ErrOverloaded = errors.New("server overloaded")
+)
+
+// NewConcurrencyLimiter returns a new limiter with the initial limit, without a queue requests
+// over the limit are rejected immediately
+func NewConcurrencyLimiter(limit int, opts ...ConcurrencyOption) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{
+		limit:      float64(limit),
+		retryAfter: defaultConcurrencyRetryAfter,
+	}
+
+	for _, o := range opts {
+		o(l)
+	}
+
+	return l
+}
+
+// Acquire waits for a slot, returning a release function that must be called when the request
+// completes; ErrOverloaded is returned if the request is shed
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, p Priority) (func(), error) {
+	if p >= PriorityCritical {
+		return func() {}, nil
+	}
+
+	l.lock.Lock()
+
+	if l.inflight < l.current() {
+		l.inflight++
+		l.lock.Unlock()
+
+		return l.releaser(), nil
+	}
+
+	if p <= PriorityLow || len(l.queue) >= l.queueSize {
+		l.lock.Unlock()
+		return nil, ErrOverloaded
+	}
+
+	w := &concurrencyWaiter{
+		priority: p,
+		ready:    make(chan struct{}),
+	}
+
+	// insert after waiters of the same or higher priority
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].priority < p {
+		i--
+	}
+
+	l.queue = append(l.queue, nil)
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = w
+
+	l.lock.Unlock()
+
+	var timeout <-chan time.Time
+
+	if l.timeout > 0 {
+		t := time.NewTimer(l.timeout)
+		defer t.Stop()
+
+		timeout = t.C
+	}
+
+	select {
+	case <-w.ready:
+		return l.releaser(), nil
+
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i, qw := range l.queue {
+		if qw == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return nil, ErrOverloaded
+		}
+	}
+
+	// the slot was granted while timing out
+	return l.releaser(), nil
+}
+
+// releaser returns the release function for an acquired slot, the handler latency is sampled
+// for adaptive limits
+func (l *ConcurrencyLimiter) releaser() func() {
+	start := time.Now()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			l.release(time.Since(start))
+		})
+	}
+}
+
+func (l *ConcurrencyLimiter) release(rtt time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.algorithm != nil {
+		l.limit = l.algorithm.update(l.limit, rtt, l.inflight)
+	}
+
+	l.inflight--
+
+	for len(l.queue) > 0 && l.inflight < l.current() {
+		w := l.queue[0]
+		l.queue = l.queue[1:]
+
+		l.inflight++
+		close(w.ready)
+	}
+}
+
+// current returns the integer limit, the lock must be held
+func (l *ConcurrencyLimiter) current() int {
+	if l.limit < 1 {
+		return 1
+	}
+	return int(l.limit)
+}
+
+// Limit returns the current concurrency limit
+func (l *ConcurrencyLimiter) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.current()
+}
+
+// InFlight returns the number of executing requests
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.inflight
+}
+
+// Queued returns the number of waiting requests
+func (l *ConcurrencyLimiter) Queued() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return len(l.queue)
+}
+
+func (a *aimdLimit) update(limit float64, rtt time.Duration, inflight int) float64 {
+	if rtt > a.threshold {
+		limit *= a.backoff
+	} else if float64(inflight)*2 >= limit {
+		// only grow when the limit is being used
+		limit++
+	}
+
+	return math.Max(a.min, math.Min(a.max, limit))
+}
+
+func (g *gradientLimit) update(limit float64, rtt time.Duration, inflight int) float64 {
+	sample := float64(rtt)
+
+	if g.long == 0 {
+		g.long = sample
+		g.short = sample
+		return limit
+	}
+
+	g.short = g.short*(1-gradientShortAlpha) + sample*gradientShortAlpha
+	g.long = g.long*(1-gradientLongAlpha) + sample*gradientLongAlpha
+
+	// let the long term latency recover quickly after a sustained drop
+	if g.long/g.short > 2 {
+		g.long *= 0.95
+	}
+
+	// the limit is not being used so latency says nothing about it
+	if float64(inflight)*2 < limit {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.long/g.short))
+	next := limit*gradient + math.Sqrt(limit)
+
+	limit = limit*(1-gradientSmoothing) + next*gradientSmoothing
+
+	return math.Max(g.min, math.Min(g.max, limit))
+}
+
+// concurrencyHandler acquires a slot from each limiter before calling the handler, shed requests
+// get a 503 with Retry-After
+func (s *Server) concurrencyHandler(next http.Handler, limiters []*ConcurrencyLimiter, p Priority) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, l := range limiters {
+			release, err := l.Acquire(r.Context(), p)
+			if err != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(durationSeconds(l.retryAfter)))
+				s.WriteError(w, http.StatusServiceUnavailable, err)
+				return
+			}
+
+			defer release()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithConcurrencyQueue enables queueing of up to size requests for at most timeout when the
+// limit is reached, a zero timeout waits until the request is canceled
+func WithConcurrencyQueue(size int, timeout time.Duration) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.queueSize = size
+		l.timeout = timeout
+	}
+}
+
+// WithConcurrencyRetryAfter sets the Retry-After delay for shed requests, the default is 1s
+func WithConcurrencyRetryAfter(d time.Duration) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.retryAfter = d
+	}
+}
+
+// WithAIMDLimit adapts the limit between min and max, backing off by 10% when a request takes
+// longer than the latency threshold
+func WithAIMDLimit(min, max int, threshold time.Duration) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.algorithm = &aimdLimit{
+			min:       float64(min),
+			max:       float64(max),
+			threshold: threshold,
+			backoff:   0.9,
+		}
+	}
+}
+
+// WithGradientLimit adapts the limit between min and max using the gradient of recent latency
+// to the long term average, no latency target is required
+func WithGradientLimit(min, max int) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.algorithm = &gradientLimit{
+			min: float64(min),
+			max: float64(max),
+		}
+	}
+}
+
+// WithGlobalConcurrencyLimit applies the limiter to every route, it is acquired before any
+// route limiter
+func WithGlobalConcurrencyLimit(l *ConcurrencyLimiter) Option {
+	return func(s *Server) {
+		s.concurrency = l
+	}
+}
+
+// WithConcurrencyLimit applies the limiter to the route, limiters may be shared between routes
+func WithConcurrencyLimit(l *ConcurrencyLimiter) RouteOption {
+	return func(r *routeOption) {
+		r.concurrency = l
+	}
+}
+
+// WithPriority sets the route priority class, PriorityCritical routes are never limited
+func WithPriority(p Priority) RouteOption {
+	return func(r *routeOption) {
+		r.priority = p
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []ConcurrencyOption
+		priority Priority
+		cancel   time.Duration
+		wantErr  error
+	}{
+		{
+			name:     "no queue",
+			priority: PriorityNormal,
+			wantErr:  ErrOverloaded,
+		},
+		{
+			name:     "critical bypasses the limit",
+			priority: PriorityCritical,
+		},
+		{
+			name:     "low priority is never queued",
+			opts:     []ConcurrencyOption{WithConcurrencyQueue(10, time.Second)},
+			priority: PriorityLow,
+			wantErr:  ErrOverloaded,
+		},
+		{
+			name:     "queue timeout",
+			opts:     []ConcurrencyOption{WithConcurrencyQueue(10, time.Millisecond*10)},
+			priority: PriorityNormal,
+			wantErr:  ErrOverloaded,
+		},
+		{
+			name:     "canceled while queued",
+			opts:     []ConcurrencyOption{WithConcurrencyQueue(10, 0)},
+			priority: PriorityHigh,
+			cancel:   time.Millisecond * 10,
+			wantErr:  ErrOverloaded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewConcurrencyLimiter(1, tt.opts...)
+
+			// hold the only slot
+			hold, err := l.Acquire(context.Background(), PriorityNormal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hold()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+
+			release, err := l.Acquire(ctx, tt.priority)
+			if err != tt.wantErr {
+				t.Fatalf("Acquire() error = %v, want %v", err, tt.wantErr)
+			}
+			if release != nil {
+				release()
+			}
+
+			if q := l.Queued(); q != 0 {
+				t.Errorf("queued = %d, want 0", q)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterPriorityOrder(t *testing.T) {
+	l := NewConcurrencyLimiter(1, WithConcurrencyQueue(10, time.Second*5))
+
+	hold, err := l.Acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 3)
+
+	waiters := []struct {
+		name     string
+		priority Priority
+	}{
+		{"normal-1", PriorityNormal},
+		{"normal-2", PriorityNormal},
+		{"high", PriorityHigh},
+	}
+
+	for i, w := range waiters {
+		w := w
+
+		go func() {
+			release, err := l.Acquire(context.Background(), w.priority)
+			if err != nil {
+				order <- err.Error()
+				return
+			}
+			order <- w.name
+			release()
+		}()
+
+		// queue the waiters in a known order
+		for deadline := time.Now().Add(time.Second * 5); l.Queued() <= i; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not queued", w.name)
+			}
+		}
+	}
+
+	hold()
+
+	want := []string{"high", "normal-1", "normal-2"}
+
+	for i, name := range want {
+		select {
+		case got := <-order:
+			if got != name {
+				t.Errorf("slot %d went to %s, want %s", i, got, name)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("slot %d was not granted", i)
+		}
+	}
+
+	if n := l.InFlight(); n != 0 {
+		t.Errorf("in flight = %d, want 0", n)
+	}
+}
+
+func TestAIMDLimit(t *testing.T) {
+	a := &aimdLimit{min: 2, max: 10, threshold: time.Millisecond * 100, backoff: 0.5}
+
+	tests := []struct {
+		name     string
+		limit    float64
+		rtt      time.Duration
+		inflight int
+		want     float64
+	}{
+		{name: "grows when used", limit: 4, rtt: time.Millisecond, inflight: 2, want: 5},
+		{name: "idle does not grow", limit: 4, rtt: time.Millisecond, inflight: 1, want: 4},
+		{name: "backs off when slow", limit: 8, rtt: time.Second, inflight: 8, want: 4},
+		{name: "bounded by min", limit: 3, rtt: time.Second, inflight: 3, want: 2},
+		{name: "bounded by max", limit: 10, rtt: time.Millisecond, inflight: 10, want: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.update(tt.limit, tt.rtt, tt.inflight); got != tt.want {
+				t.Errorf("update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrencyHandlerSheds(t *testing.T) {
+	s := NewServer()
+
+	l := NewConcurrencyLimiter(1, WithConcurrencyRetryAfter(time.Second*3))
+
+	hold, _ := l.Acquire(context.Background(), PriorityNormal)
+	defer hold()
+
+	h := s.concurrencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("shed request reached the handler")
+	}), []*ConcurrencyLimiter{l}, PriorityNormal)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("response = %d Retry-After %q, want 503 and 3", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,320 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// CORSPolicy is a cross-origin resource sharing policy
+	CORSPolicy struct {
+		// AllowedOrigins are the allowed origins, "*" allows any origin and a wildcard
+		// subdomain pattern like "https://*.example.com" allows any subdomain
+		AllowedOrigins []string
+
+		// AllowOriginFunc is called for origins not in AllowedOrigins
+		AllowOriginFunc func(origin string) bool
+
+		// AllowedMethods are the allowed methods, by default the methods registered for the path
+		AllowedMethods []string
+
+		// AllowedHeaders are the allowed request headers, "*" allows any header
+		AllowedHeaders []string
+
+		// ExposedHeaders are the response headers exposed to the client
+		ExposedHeaders []string
+
+		// AllowCredentials allows cookies and authorization headers, origins only allowed by "*"
+		// get a literal "*" without credentials
+		AllowCredentials bool
+
+		// MaxAge is how long the preflight response may be cached
+		MaxAge time.Duration
+
+		// AllowPrivateNetwork allows requests from public networks to this private network server
+		AllowPrivateNetwork bool
+	}
+)
+
+var (
+	// ErrCORSNotAllowed is returned when a preflight request is not allowed by the policy
+	ErrCORSNotAllowed = errors.New("cross-origin request not allowed")
+
+	defaultCORSHeaders = []string{
+		"Accept",
+		"Accept-Language",
+		"Authorization",
+		"Content-Type",
+		"Content-Language",
+		"Origin",
+		"Range",
+		"If-Modified-Since",
+		"X-Forwarded-For",
+		"X-Original-Method",
+		"X-Redirected-From",
+	}
+
+	defaultCORSExposedHeaders = []string{
+		"X-Total-Count",
+		"X-Atom-Link",
+		"X-Last-Entry-Date",
+		"Server",
+		"Content-Length",
+		"Content-Range",
+		"Content-Encoding",
+	}
+)
+
+// allowOrigin returns true if the origin is allowed by the policy
+func (p *CORSPolicy) allowOrigin(origin string) bool {
+	return p.matchOrigin(origin, true)
+}
+
+// allowCredentials returns true if credentials are allowed for the origin, origins only
+// allowed by "*" never get credentials
+func (p *CORSPolicy) allowCredentials(origin string) bool {
+	return p.AllowCredentials && p.matchOrigin(origin, false)
+}
+
+// matchOrigin matches the origin against the policy, "*" is only matched if wildcard is true
+func (p *CORSPolicy) matchOrigin(origin string, wildcard bool) bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			if wildcard {
+				return true
+			}
+			continue
+		}
+
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+
+		if i := strings.Index(o, "*"); i >= 0 {
+			prefix, suffix := strings.ToLower(o[:i]), strings.ToLower(o[i+1:])
+			lo := strings.ToLower(origin)
+
+			if len(lo) > len(prefix)+len(suffix) && strings.HasPrefix(lo, prefix) && strings.HasSuffix(lo, suffix) {
+				// the wildcard only matches subdomain labels
+				if !strings.ContainsAny(lo[len(prefix):len(lo)-len(suffix)], "/:") {
+					return true
+				}
+			}
+		}
+	}
+
+	if p.AllowOriginFunc != nil {
+		return p.AllowOriginFunc(origin)
+	}
+
+	return false
+}
+
+// headers writes the response headers common to preflight and actual requests
+func (p *CORSPolicy) headers(w http.ResponseWriter, origin string) {
+	h := w.Header()
+
+	switch {
+	case p.allowCredentials(origin):
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+
+	case stringsContain(p.AllowedOrigins, "*"):
+		h.Set("Access-Control-Allow-Origin", "*")
+
+	default:
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// handle writes the headers for an actual cross-origin request
+func (p *CORSPolicy) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !p.allowOrigin(origin) {
+		return
+	}
+
+	p.headers(w, origin)
+
+	exposed := p.ExposedHeaders
+	if exposed == nil {
+		exposed = defaultCORSExposedHeaders
+	}
+
+	if len(exposed) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+	}
+}
+
+// preflight validates and responds to a preflight request for the allowed methods
+func (p *CORSPolicy) preflight(w http.ResponseWriter, r *http.Request, methods []string) error {
+	origin := r.Header.Get("Origin")
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	if !p.allowOrigin(origin) {
+		return ErrCORSNotAllowed
+	}
+
+	if len(p.AllowedMethods) > 0 {
+		methods = p.AllowedMethods
+	}
+
+	if !stringsContain(methods, method) {
+		return ErrCORSNotAllowed
+	}
+
+	allowed := p.AllowedHeaders
+	if allowed == nil {
+		allowed = defaultCORSHeaders
+	}
+
+	requested := headerList(r.Header.Values("Access-Control-Request-Headers"))
+
+	if !stringsContain(allowed, "*") {
+		for _, rh := range requested {
+			ok := false
+			for _, a := range allowed {
+				if strings.EqualFold(a, rh) {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return ErrCORSNotAllowed
+			}
+		}
+	}
+
+	p.headers(w, origin)
+
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+
+	if p.AllowPrivateNetwork && r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+		h.Set("Access-Control-Allow-Private-Network", "true")
+	}
+
+	return nil
+}
+
+// corsPolicy returns the route handler policy or the server policy
+func (s *Server) corsPolicy(h *routeHandler) *CORSPolicy {
+	if h != nil && h.opt.cors != nil {
+		return h.opt.cors
+	}
+	return s.cors
+}
+
+// pathMethods returns the methods registered for the path, including HEAD for GET routes; the
+// route lock must be held
+func (s *Server) pathMethods(path string) []string {
+	methods := make([]string, 0)
+
+	for _, rt := range s.routes {
+		if rt.path == path {
+			methods = append(methods, rt.method)
+		}
+	}
+
+	if stringsContain(methods, http.MethodGet) && !stringsContain(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+
+	sort.Strings(methods)
+
+	return methods
+}
+
+// optionsHandler answers OPTIONS requests for the path, preflight requests are answered from
+// the cors policy of the requested method's route and other requests get the Allow header
+// unless an OPTIONS route was added
+func (s *Server) optionsHandler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.routeLock.Lock()
+		methods := s.pathMethods(path)
+		options := s.routes[http.MethodOptions+" "+path]
+		target := s.routes[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))+" "+path]
+		s.routeLock.Unlock()
+
+		if r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			var h *routeHandler
+			if target != nil {
+				h = target.match(s.requestSemver(r))
+			}
+
+			if p := s.corsPolicy(h); p != nil {
+				if err := p.preflight(w, r, methods); err != nil {
+					s.WriteError(w, http.StatusForbidden, err)
+					return
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		if options != nil {
+			s.routeDispatcher(options).ServeHTTP(w, r)
+			return
+		}
+
+		if !stringsContain(methods, http.MethodOptions) {
+			methods = append(methods, http.MethodOptions)
+			sort.Strings(methods)
+		}
+
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// WithCORS enables cors for the origins with the default headers, credentials are allowed
+// unless the origins include "*"
+func WithCORS(origin ...string) Option {
+	return func(s *Server) {
+		s.cors = &CORSPolicy{
+			AllowedOrigins:   origin,
+			AllowCredentials: !stringsContain(origin, "*"),
+		}
+	}
+}
+
+// WithCORSPolicy sets the server cors policy
+func WithCORSPolicy(p CORSPolicy) Option {
+	return func(s *Server) {
+		s.cors = &p
+	}
+}
+
+// WithRouteCORS overrides the server cors policy for the route
+func WithRouteCORS(p CORSPolicy) RouteOption {
+	return func(r *routeOption) {
+		r.cors = &p
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSAllowOrigin(t *testing.T) {
+	p := &CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		AllowOriginFunc: func(origin string) bool {
+			return strings.HasSuffix(origin, ".internal")
+		},
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://other.example.com", false},
+		{"https://api.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"http://api.example.org", false},
+		{"https://evil.com/.example.org", false},
+		{"https://evil.com:443.example.org", false},
+		{"http://admin.internal", true},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.allowOrigin(tt.origin); got != tt.want {
+			t.Errorf("allowOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	s := NewServer(WithBasepath("/"), WithCORSPolicy(CORSPolicy{
+		AllowedOrigins:      []string{"https://app.example.com"},
+		AllowCredentials:    true,
+		MaxAge:              time.Hour,
+		AllowPrivateNetwork: true,
+	}))
+
+	ok := func(ctx context.Context) Responder {
+		return NewResponse("ok")
+	}
+
+	s.AddRoute("/items", ok, WithMethod(http.MethodGet))
+	s.AddRoute("/items", ok, WithMethod(http.MethodPost))
+	s.AddRoute("/public", ok, WithRouteCORS(CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"*"},
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		headers     map[string]string
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "preflight",
+			method:     http.MethodOptions,
+			path:       "/items",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type, authorization"},
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, HEAD, POST",
+				"Access-Control-Allow-Headers":     "content-type, authorization",
+				"Access-Control-Max-Age":           "3600",
+			},
+		},
+		{
+			name:       "preflight with private network",
+			method:     http.MethodOptions,
+			path:       "/items",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Private-Network": "true"},
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Private-Network": "true",
+			},
+		},
+		{
+			name:       "preflight from disallowed origin",
+			method:     http.MethodOptions,
+			path:       "/items",
+			headers:    map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "GET"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "preflight for unregistered method",
+			method:     http.MethodOptions,
+			path:       "/items",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "preflight with disallowed header",
+			method:     http.MethodOptions,
+			path:       "/items",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "x-secret"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "preflight with route policy",
+			method:     http.MethodOptions,
+			path:       "/public",
+			headers:    map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "x-secret"},
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Headers": "x-secret",
+			},
+		},
+		{
+			name:       "options without preflight",
+			method:     http.MethodOptions,
+			path:       "/items",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Allow": "GET, HEAD, OPTIONS, POST",
+			},
+		},
+		{
+			name:       "actual request",
+			method:     http.MethodGet,
+			path:       "/items",
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Expose-Headers": strings.Join(defaultCORSExposedHeaders, ", "),
+				"Vary":                          "Origin",
+			},
+		},
+		{
+			name:       "actual request from disallowed origin",
+			method:     http.MethodGet,
+			path:       "/items",
+			headers:    map[string]string{"Origin": "https://evil.com"},
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "Origin",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			for k, v := range tt.wantHeaders {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	tests := []struct {
+		name            string
+		opt             Option
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{
+			name:       "any origin",
+			opt:        WithCORS("*"),
+			origin:     "https://evil.example",
+			wantOrigin: "*",
+		},
+		{
+			name:            "listed origin",
+			opt:             WithCORS("https://app.example.com"),
+			origin:          "https://app.example.com",
+			wantOrigin:      "https://app.example.com",
+			wantCredentials: "true",
+		},
+		{
+			name:       "any origin policy with credentials",
+			opt:        WithCORSPolicy(CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}),
+			origin:     "https://evil.example",
+			wantOrigin: "*",
+		},
+		{
+			name:            "listed origin with any origin",
+			opt:             WithCORSPolicy(CORSPolicy{AllowedOrigins: []string{"*", "https://app.example.com"}, AllowCredentials: true}),
+			origin:          "https://app.example.com",
+			wantOrigin:      "https://app.example.com",
+			wantCredentials: "true",
+		},
+		{
+			name:            "origin func",
+			opt:             WithCORSPolicy(CORSPolicy{AllowOriginFunc: func(string) bool { return true }, AllowCredentials: true}),
+			origin:          "https://partner.example",
+			wantOrigin:      "https://partner.example",
+			wantCredentials: "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithBasepath("/"), tt.opt)
+
+			s.AddRoute("/items", func(ctx context.Context) Responder {
+				return NewResponse("ok")
+			})
+
+			for _, method := range []string{http.MethodGet, http.MethodOptions} {
+				r := httptest.NewRequest(method, "/items", nil)
+				r.Header.Set("Origin", tt.origin)
+				if method == http.MethodOptions {
+					r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+				}
+
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+					t.Errorf("%s Access-Control-Allow-Origin = %q, want %q", method, got, tt.wantOrigin)
+				}
+
+				if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+					t.Errorf("%s Access-Control-Allow-Credentials = %q, want %q", method, got, tt.wantCredentials)
+				}
+			}
+		})
+	}
+}
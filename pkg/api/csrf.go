@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type (
+	// CSRFMode is the csrf protection strategy
+	CSRFMode int
+
+	// CSRFOption defines csrf options
+	CSRFOption func(*csrfOptions)
+
+	csrfOptions struct {
+		mode     CSRFMode
+		header   string
+		field    string
+		cookie   string
+		path     string
+		domain   string
+		secure   bool
+		sameSite http.SameSite
+		key      []byte
+	}
+)
+
+const (
+	// CSRFDoubleSubmit compares the token in a cookie with the token in the request header or
+	// form field; it does not require a session
+	CSRFDoubleSubmit CSRFMode = iota
+
+	// CSRFSynchronizer compares the request token with the session token, routes must use
+	// the SessionAuthorizer; unsafe requests without a session are rejected
+	CSRFSynchronizer
+)
+
+const (
+	defaultCSRFHeader = "X-CSRF-Token"
+	defaultCSRFField  = "csrf_token"
+	defaultCSRFCookie = "csrf_token"
+)
+
+var (
+	// ErrCSRFTokenMissing is returned when an unsafe request has no csrf token
+	ErrCSRFTokenMissing = errors.New("csrf token missing")
+
+	// ErrCSRFTokenInvalid is returned when the csrf token does not match
+	ErrCSRFTokenInvalid = errors.New("csrf token invalid")
+
+	contextKeyCSRFToken = contextKey("csrf-token")
+)
+
+func newCSRFOptions(opts ...CSRFOption) *csrfOptions {
+	o := &csrfOptions{
+		mode:     CSRFDoubleSubmit,
+		header:   defaultCSRFHeader,
+		field:    defaultCSRFField,
+		cookie:   defaultCSRFCookie,
+		path:     "/",
+		secure:   true,
+		sameSite: http.SameSiteLaxMode,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// protect verifies the token for unsafe methods and returns the request with the current token in
+// the context; for double submit the token cookie is issued if it is not present, for synchronizer
+// a token is generated and saved if the session does not have one
+func (o *csrfOptions) protect(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	var token string
+
+	session := RequestSession(r.Context())
+
+	switch o.mode {
+	case CSRFSynchronizer:
+		if session == nil {
+			if csrfSafeMethod(r.Method) {
+				return r, nil
+			}
+			return r, ErrCSRFTokenInvalid
+		}
+		token = session.CSRFToken
+
+	default:
+		if c, err := r.Cookie(o.cookie); err == nil && o.valid(session, c.Value) {
+			token = c.Value
+		}
+	}
+
+	if !csrfSafeMethod(r.Method) {
+		sent := r.Header.Get(o.header)
+		if sent == "" && o.field != "" {
+			sent = r.PostFormValue(o.field)
+		}
+
+		if sent == "" {
+			return r, ErrCSRFTokenMissing
+		}
+
+		if token == "" || !hmac.Equal([]byte(sent), []byte(token)) {
+			return r, ErrCSRFTokenInvalid
+		}
+	}
+
+	if token == "" && o.mode == CSRFSynchronizer {
+		session.CSRFToken = randomToken(32)
+		token = session.CSRFToken
+
+		if session.store != nil {
+			if err := session.store.Save(w, r, session); err != nil {
+				return r, err
+			}
+		}
+	}
+
+	if token == "" {
+		token = o.generate(session)
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     o.cookie,
+			Value:    token,
+			Path:     o.path,
+			Domain:   o.domain,
+			Secure:   o.secure,
+			SameSite: o.sameSite,
+		})
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), contextKeyCSRFToken, token)), nil
+}
+
+// generate returns a new random token, signed if the key is set
+func (o *csrfOptions) generate(session *Session) string {
+	token := randomToken(32)
+
+	if len(o.key) > 0 {
+		token += "." + o.sign(session, token)
+	}
+
+	return token
+}
+
+// valid checks the double submit token signature, unsigned tokens are always valid
+func (o *csrfOptions) valid(session *Session, token string) bool {
+	if len(o.key) == 0 {
+		return token != ""
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	return hmac.Equal([]byte(parts[1]), []byte(o.sign(session, parts[0])))
+}
+
+// sign returns the token signature, bound to the session id if there is a session
+func (o *csrfOptions) sign(session *Session, value string) string {
+	mac := hmac.New(sha256.New, o.key)
+
+	if session != nil {
+		mac.Write([]byte(session.ID))
+	}
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// CSRFToken returns the csrf token for the request to be included in forms or headers, this is
+// only set for browser routes
+func CSRFToken(ctx context.Context) string {
+	if t, ok := ctx.Value(contextKeyCSRFToken).(string); ok {
+		return t
+	}
+	return ""
+}
+
+// WithCSRF sets the csrf protection options for browser routes, by default browser routes use
+// the double submit cookie strategy
+func WithCSRF(opts ...CSRFOption) Option {
+	return func(s *Server) {
+		s.csrf = newCSRFOptions(opts...)
+	}
+}
+
+// WithCSRFMode sets the csrf strategy
+func WithCSRFMode(mode CSRFMode) CSRFOption {
+	return func(o *csrfOptions) {
+		o.mode = mode
+	}
+}
+
+// WithCSRFHeader sets the request header the token is read from
+func WithCSRFHeader(header string) CSRFOption {
+	return func(o *csrfOptions) {
+		o.header = header
+	}
+}
+
+// WithCSRFField sets the form field the token is read from if the header is not set, an empty
+// field disables form tokens
+func WithCSRFField(field string) CSRFOption {
+	return func(o *csrfOptions) {
+		o.field = field
+	}
+}
+
+// WithCSRFCookie sets the double submit cookie name, path and domain
+func WithCSRFCookie(name, path, domain string) CSRFOption {
+	return func(o *csrfOptions) {
+		o.cookie = name
+		o.path = path
+		o.domain = domain
+	}
+}
+
+// WithCSRFKey signs double submit tokens with the key; on routes with a session the signature is
+// bound to the session id, so a token injected into the cookie from a sibling domain is only
+// accepted if it was issued for the victim's session. Without a session the signature only
+// proves the token was issued by the server, it does not prevent cookie injection.
+func WithCSRFKey(key []byte) CSRFOption {
+	return func(o *csrfOptions) {
+		o.key = key
+	}
+}
+
+// WithCSRFInsecure allows the double submit cookie over plain http, this should only be used in
+// development
+func WithCSRFInsecure() CSRFOption {
+	return func(o *csrfOptions) {
+		o.secure = false
+	}
+}
+
+// WithCSRFSameSite sets the double submit cookie SameSite mode, the default is lax
+func WithCSRFSameSite(mode http.SameSite) CSRFOption {
+	return func(o *csrfOptions) {
+		o.sameSite = mode
+	}
+}
+
+// WithBrowser marks the route as a browser route, unsafe methods require a valid csrf token
+func WithBrowser() RouteOption {
+	return func(r *routeOption) {
+		r.browser = true
+	}
+}
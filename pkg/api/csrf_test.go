@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	key := []byte("csrf-key")
+
+	doubleSubmit := newCSRFOptions(WithCSRFKey(key))
+	synchronizer := newCSRFOptions(WithCSRFMode(CSRFSynchronizer))
+
+	alice := &Session{ID: "alice"}
+	mallory := &Session{ID: "mallory"}
+
+	aliceToken := doubleSubmit.generate(alice)
+	malloryToken := doubleSubmit.generate(mallory)
+
+	tests := []struct {
+		name       string
+		opts       *csrfOptions
+		method     string
+		session    *Session
+		cookie     string
+		sent       string
+		wantErr    error
+		wantToken  string
+		wantCookie bool
+	}{
+		{
+			name:       "safe request issues a token",
+			opts:       doubleSubmit,
+			method:     "GET",
+			wantCookie: true,
+		},
+		{
+			name:      "safe request keeps the token",
+			opts:      doubleSubmit,
+			method:    "GET",
+			session:   alice,
+			cookie:    aliceToken,
+			wantToken: aliceToken,
+		},
+		{
+			name:    "unsafe request without a token",
+			opts:    doubleSubmit,
+			method:  "POST",
+			cookie:  aliceToken,
+			session: alice,
+			wantErr: ErrCSRFTokenMissing,
+		},
+		{
+			name:      "unsafe request with the token",
+			opts:      doubleSubmit,
+			method:    "POST",
+			session:   alice,
+			cookie:    aliceToken,
+			sent:      aliceToken,
+			wantToken: aliceToken,
+		},
+		{
+			name:    "unsafe request with a different token",
+			opts:    doubleSubmit,
+			method:  "DELETE",
+			session: alice,
+			cookie:  aliceToken,
+			sent:    "other",
+			wantErr: ErrCSRFTokenInvalid,
+		},
+		{
+			name:    "injected token from another session",
+			opts:    doubleSubmit,
+			method:  "POST",
+			session: alice,
+			cookie:  malloryToken,
+			sent:    malloryToken,
+			wantErr: ErrCSRFTokenInvalid,
+		},
+		{
+			name:    "unsigned token",
+			opts:    doubleSubmit,
+			method:  "POST",
+			cookie:  "forged",
+			sent:    "forged",
+			wantErr: ErrCSRFTokenInvalid,
+		},
+		{
+			name:   "synchronizer safe request without a session",
+			opts:   synchronizer,
+			method: "GET",
+		},
+		{
+			name:    "synchronizer unsafe request without a session",
+			opts:    synchronizer,
+			method:  "POST",
+			sent:    "token",
+			wantErr: ErrCSRFTokenInvalid,
+		},
+		{
+			name:      "synchronizer session token",
+			opts:      synchronizer,
+			method:    "PUT",
+			session:   &Session{ID: "bob", CSRFToken: "bob-token"},
+			sent:      "bob-token",
+			wantToken: "bob-token",
+		},
+		{
+			name:    "synchronizer session without a token",
+			opts:    synchronizer,
+			method:  "POST",
+			session: &Session{ID: "carol"},
+			sent:    "guess",
+			wantErr: ErrCSRFTokenInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/form", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: defaultCSRFCookie, Value: tt.cookie})
+			}
+			if tt.sent != "" {
+				r.Header.Set(defaultCSRFHeader, tt.sent)
+			}
+			if tt.session != nil {
+				r = r.WithContext(context.WithValue(r.Context(), contextKeySession, tt.session))
+			}
+
+			w := httptest.NewRecorder()
+
+			r, err := tt.opts.protect(w, r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			token := CSRFToken(r.Context())
+			if tt.wantToken != "" && token != tt.wantToken {
+				t.Errorf("token = %q, want %q", token, tt.wantToken)
+			}
+
+			if cookie := w.Header().Get("Set-Cookie") != ""; cookie != tt.wantCookie {
+				t.Errorf("cookie set = %v, want %v", cookie, tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestCSRFSynchronizerGeneratesSessionToken(t *testing.T) {
+	store, err := NewMemorySessionStore([][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := NewSession(store)
+	session.CSRFToken = ""
+
+	r := httptest.NewRequest("GET", "/form", nil)
+	r = r.WithContext(context.WithValue(r.Context(), contextKeySession, session))
+
+	w := httptest.NewRecorder()
+
+	r, err = newCSRFOptions(WithCSRFMode(CSRFSynchronizer)).protect(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := CSRFToken(r.Context())
+	if token == "" || token != session.CSRFToken {
+		t.Fatalf("token = %q, session token = %q", token, session.CSRFToken)
+	}
+
+	// the token is saved with the session rather than issued as a double submit cookie
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != defaultSessionName {
+		t.Fatalf("cookies = %v, want the session cookie", cookies)
+	}
+
+	next := httptest.NewRequest("POST", "/form", nil)
+	next.AddCookie(cookies[0])
+
+	loaded, err := store.Load(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.CSRFToken != token {
+		t.Errorf("saved token = %q, want %q", loaded.CSRFToken, token)
+	}
+}
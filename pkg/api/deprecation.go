@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/blang/semver/v4"
+)
+
+type (
+	// Deprecation describes a deprecated route or version
+	Deprecation struct {
+		// Since is when the route was deprecated, if zero the Deprecation header is true
+		Since time.Time
+
+		// Sunset is when the route will stop responding, it is optional
+		Sunset time.Time
+
+		// Successor is the url of the replacement, sent as a successor-version link
+		Successor string
+
+		// Policy is the url of the deprecation policy or documentation, sent as a deprecation link
+		Policy string
+
+		// Enforce responds with 410 Gone after the sunset
+		Enforce bool
+	}
+
+	// DeprecationUsage is the usage of a deprecated route by a client
+	DeprecationUsage struct {
+		Route    string    `json:"route"`
+		Version  string    `json:"version"`
+		Client   string    `json:"client"`
+		Count    int64     `json:"count"`
+		LastSeen time.Time `json:"last_seen"`
+	}
+
+	versionDeprecation struct {
+		versions    semver.Range
+		deprecation Deprecation
+	}
+
+	// deprecationTracker keeps the usage in least recently seen order so the oldest entries
+	// are evicted when the limit is reached
+	deprecationTracker struct {
+		usage map[string]*list.Element
+		order *list.List
+		lock  sync.Mutex
+	}
+
+	deprecationEntry struct {
+		key   string
+		usage DeprecationUsage
+	}
+)
+
+const (
+	maxDeprecationUsage = 10000
+)
+
+var (
+	// ErrSunset is returned for requests to a route after its sunset
+	ErrSunset = errors.New("this api is no longer available")
+)
+
+func newDeprecationTracker() *deprecationTracker {
+	return &deprecationTracker{
+		usage: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// deprecation returns the route deprecation, or the version deprecation if the route has none
+func (s *Server) deprecation(h *routeHandler, v semver.Version) *Deprecation {
+	if h.opt.deprecation != nil {
+		return h.opt.deprecation
+	}
+
+	for _, vd := range s.versionDeprecations {
+		if vd.versions(v) {
+			return &vd.deprecation
+		}
+	}
+
+	return nil
+}
+
+// deprecate writes the deprecation headers and records the usage, it returns false if the route
+// is past an enforced sunset and the request was rejected
+func (s *Server) deprecate(w http.ResponseWriter, r *http.Request, rt *route, d *Deprecation, v semver.Version) bool {
+	if d.Since.IsZero() {
+		w.Header().Set("Deprecation", "true")
+	} else {
+		w.Header().Set("Deprecation", d.Since.UTC().Format(http.TimeFormat))
+	}
+
+	if !d.Sunset.IsZero() {
+		w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+
+	if d.Successor != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, d.Successor))
+	}
+
+	if d.Policy != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, d.Policy))
+	}
+
+	name := rt.method + " " + rt.path
+	client := requestClientIP(r)
+
+	// only the first request from each client is logged, usage is available from DeprecationUsage
+	if s.deprecations.record(name, v.String(), client) {
+		s.log.WithFields(log.Fields{
+			"route":   name,
+			"version": v.String(),
+			"client":  client,
+		}).Info("deprecated route requested")
+	}
+
+	if d.Enforce && !d.Sunset.IsZero() && time.Now().After(d.Sunset) {
+		s.WriteError(w, http.StatusGone, ErrSunset)
+		return false
+	}
+
+	return true
+}
+
+// record updates the usage, returning true if this is the first request for the route, version
+// and client; the least recently seen entry is evicted when the limit is reached
+func (t *deprecationTracker) record(route, version, client string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := route + "|" + version + "|" + client
+
+	if el, ok := t.usage[key]; ok {
+		e := el.Value.(*deprecationEntry)
+		e.usage.Count++
+		e.usage.LastSeen = time.Now()
+
+		t.order.MoveToFront(el)
+
+		return false
+	}
+
+	if t.order.Len() >= maxDeprecationUsage {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.usage, oldest.Value.(*deprecationEntry).key)
+	}
+
+	t.usage[key] = t.order.PushFront(&deprecationEntry{
+		key: key,
+		usage: DeprecationUsage{
+			Route:    route,
+			Version:  version,
+			Client:   client,
+			Count:    1,
+			LastSeen: time.Now(),
+		},
+	})
+
+	return true
+}
+
+// DeprecationUsage returns the usage of deprecated routes by client, most recent first; usage is
+// tracked for the 10000 most recently seen route, version and client combinations
+func (s *Server) DeprecationUsage() []DeprecationUsage {
+	s.deprecations.lock.Lock()
+	defer s.deprecations.lock.Unlock()
+
+	usage := make([]DeprecationUsage, 0, s.deprecations.order.Len())
+
+	for el := s.deprecations.order.Front(); el != nil; el = el.Next() {
+		usage = append(usage, el.Value.(*deprecationEntry).usage)
+	}
+
+	return usage
+}
+
+// WithDeprecation marks the route handler as deprecated, this overrides any version deprecation
+func WithDeprecation(d Deprecation) RouteOption {
+	return func(r *routeOption) {
+		r.deprecation = &d
+	}
+}
+
+// WithVersionDeprecation marks all routes in the semver range as deprecated, i.e. "<2.0.0"; the
+// first matching range is used and invalid ranges are ignored
+func WithVersionDeprecation(versions string, d Deprecation) Option {
+	return func(s *Server) {
+		r, err := semver.ParseRange(versions)
+		if err != nil {
+			s.log.Errorf("invalid deprecation version range %s: %s", versions, err)
+			return
+		}
+
+		s.versionDeprecations = append(s.versionDeprecations, versionDeprecation{
+			versions:    r,
+			deprecation: d,
+		})
+	}
+}
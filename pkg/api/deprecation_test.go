@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestDeprecatedRoute(t *testing.T) {
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		deprecation Deprecation
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			name:        "deprecated",
+			deprecation: Deprecation{},
+			wantStatus:  200,
+			wantHeaders: map[string]string{"Deprecation": "true"},
+		},
+		{
+			name: "sunset with successor",
+			deprecation: Deprecation{
+				Since:     since,
+				Sunset:    time.Now().Add(time.Hour),
+				Successor: "/v2/items",
+			},
+			wantStatus: 200,
+			wantHeaders: map[string]string{
+				"Deprecation": "Wed, 01 Jan 2020 00:00:00 GMT",
+				"Link":        `</v2/items>; rel="successor-version"`,
+			},
+		},
+		{
+			name: "sunset not enforced",
+			deprecation: Deprecation{
+				Sunset: time.Now().Add(-time.Hour),
+			},
+			wantStatus: 200,
+		},
+		{
+			name: "sunset enforced",
+			deprecation: Deprecation{
+				Sunset:  time.Now().Add(-time.Hour),
+				Enforce: true,
+			},
+			wantStatus: 410,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := memory.New()
+
+			s := NewServer(WithBasepath("/"), WithLog(&log.Logger{Handler: logs, Level: log.InfoLevel}))
+
+			s.AddRoute("/items", func(ctx context.Context) Responder {
+				return NewResponse("items")
+			}, WithDeprecation(tt.deprecation))
+
+			for i := 0; i < 3; i++ {
+				r := httptest.NewRequest("GET", "/items", nil)
+				r.RemoteAddr = "198.51.100.1:5000"
+
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+
+				if w.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+				}
+
+				for k, v := range tt.wantHeaders {
+					if got := w.Header().Get(k); got != v {
+						t.Errorf("%s = %q, want %q", k, got, v)
+					}
+				}
+			}
+
+			usage := s.DeprecationUsage()
+			if len(usage) != 1 || usage[0].Count != 3 || usage[0].Route != "GET /items" || usage[0].Client != "198.51.100.1" {
+				t.Errorf("usage = %+v, want 3 requests from the client", usage)
+			}
+
+			if len(logs.Entries) != 1 {
+				t.Errorf("logged %d entries, want 1", len(logs.Entries))
+			}
+		})
+	}
+}
+
+func TestDeprecationTrackerEviction(t *testing.T) {
+	tr := newDeprecationTracker()
+
+	if !tr.record("GET /items", "1.0.0", "first") {
+		t.Fatal("first request was not reported as new")
+	}
+
+	for i := 0; i < maxDeprecationUsage-1; i++ {
+		tr.record("GET /items", "1.0.0", strconv.Itoa(i))
+	}
+
+	// seeing the first client again makes it the most recent
+	if tr.record("GET /items", "1.0.0", "first") {
+		t.Error("repeat request was reported as new")
+	}
+
+	if !tr.record("GET /items", "1.0.0", "new") {
+		t.Error("request over the limit was not recorded")
+	}
+
+	if len(tr.usage) != maxDeprecationUsage {
+		t.Errorf("tracked %d entries, want %d", len(tr.usage), maxDeprecationUsage)
+	}
+
+	if _, ok := tr.usage["GET /items|1.0.0|0"]; ok {
+		t.Error("least recently seen entry was not evicted")
+	}
+
+	if _, ok := tr.usage["GET /items|1.0.0|first"]; !ok {
+		t.Error("recently seen entry was evicted")
+	}
+}
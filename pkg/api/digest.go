@@ -0,0 +1,403 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// DigestVerifier returns the HA1 hash, H(username:realm:password), for the user and digest
+	// algorithm (MD5 or SHA-256) along with the optional principal; this allows credentials to
+	// be stored without the plain text password, see DigestHA1
+	DigestVerifier func(ctx context.Context, username, realm, algorithm string) (ha1 string, p *Principal, err error)
+
+	// DigestOption defines digest authorizer options
+	DigestOption func(*digestAuthorizer)
+
+	digestAuthorizer struct {
+		verify     DigestVerifier
+		realm      string
+		algorithms []string
+		opaque     string
+		nonces     *nonceManager
+	}
+
+	// nonceManager issues and validates stateless hmac signed nonces, tracking the nonce
+	// count of each nonce in use to prevent replays
+	nonceManager struct {
+		secret []byte
+		ttl    time.Duration
+		counts map[string]uint64
+		pruned time.Time
+		lock   sync.Mutex
+	}
+
+	digestCredentials map[string]string
+)
+
+const (
+	// DigestMD5 is the legacy MD5 digest algorithm
+	DigestMD5 = "MD5"
+
+	// DigestSHA256 is the SHA-256 digest algorithm
+	DigestSHA256 = "SHA-256"
+
+	defaultDigestNonceTTL = time.Minute * 5
+
+	nonceSize = 8 + 16 + sha256.Size
+
+	noncePruneInterval = time.Minute
+)
+
+var (
+	// ErrDigestAuthMissing is returned when the request has no digest credentials
+	ErrDigestAuthMissing = fmt.Errorf("%w: digest auth", ErrCredentialsMissing)
+
+	// ErrDigestNonceInvalid is returned when the nonce is invalid or has been replayed
+	ErrDigestNonceInvalid = errors.New("digest nonce invalid")
+
+	// ErrDigestNonceStale is returned when the nonce has expired and the client should retry
+	ErrDigestNonceStale = errors.New("digest nonce stale")
+
+	digestHashes = map[string]func() hash.Hash{
+		DigestMD5:    md5.New,
+		DigestSHA256: sha256.New,
+	}
+)
+
+// DigestAuthorizer returns an Authorizer that verifies HTTP Digest credentials (RFC 7616) using
+// the auth quality of protection; SHA-256 is offered first, followed by MD5 for legacy clients
+func DigestAuthorizer(verify DigestVerifier, opts ...DigestOption) Authorizer {
+	a := &digestAuthorizer{
+		verify:     verify,
+		realm:      defaultAuthRealm,
+		algorithms: []string{DigestSHA256, DigestMD5},
+		nonces:     newNonceManager(defaultDigestNonceTTL),
+	}
+
+	opaque := make([]byte, 16)
+	rand.Read(opaque)
+	a.opaque = hex.EncodeToString(opaque)
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a.authorize
+}
+
+func (a *digestAuthorizer) authorize(r *http.Request) (context.Context, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "digest ") {
+		return nil, a.error(ErrDigestAuthMissing, false)
+	}
+
+	params := parseAuthParams(auth[7:])
+
+	alg := params["algorithm"]
+	if alg == "" {
+		alg = DigestMD5
+	}
+
+	if !stringsContain(a.algorithms, alg) {
+		return nil, a.error(fmt.Errorf("unsupported digest algorithm %s", alg), false)
+	}
+
+	if params["realm"] != a.realm || params["opaque"] != a.opaque {
+		return nil, a.error(ErrInvalidCredentials, false)
+	}
+
+	if params["qop"] != "auth" || params["cnonce"] == "" || params["response"] == "" {
+		return nil, a.error(errors.New("digest qop auth required"), false)
+	}
+
+	if params["uri"] != r.RequestURI {
+		return nil, a.error(errors.New("digest uri mismatch"), false)
+	}
+
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil {
+		return nil, a.error(ErrDigestNonceInvalid, false)
+	}
+
+	if err := a.nonces.validate(params["nonce"], nc); err != nil {
+		return nil, a.error(err, err == ErrDigestNonceStale)
+	}
+
+	username := params["username"]
+
+	ha1, p, err := a.verify(r.Context(), username, a.realm, alg)
+	if err != nil {
+		return nil, a.error(ErrInvalidCredentials, false)
+	}
+
+	h := digestHashes[alg]
+
+	ha2 := digestHash(h, r.Method, params["uri"])
+	expected := digestHash(h, ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2)
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 {
+		return nil, a.error(ErrInvalidCredentials, false)
+	}
+
+	// the nonce count is only recorded for verified requests so it cannot be advanced by others
+	if err := a.nonces.use(params["nonce"], nc); err != nil {
+		return nil, a.error(err, false)
+	}
+
+	if p == nil {
+		p = &Principal{
+			Subject: username,
+		}
+	}
+
+	if p.Type == "" {
+		p.Type = "digest"
+	}
+
+	return ContextWithPrincipal(r.Context(), p), nil
+}
+
+// error returns an AuthError with a challenge for each algorithm
+func (a *digestAuthorizer) error(err error, stale bool) error {
+	ae := &AuthError{
+		status: http.StatusUnauthorized,
+		err:    err,
+	}
+
+	nonce := a.nonces.issue()
+
+	for _, alg := range a.algorithms {
+		c := fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="%s", opaque="%s"`,
+			challengeEscaper.Replace(a.realm), alg, nonce, a.opaque)
+
+		if stale {
+			c += ", stale=true"
+		}
+
+		ae.challenges = append(ae.challenges, c)
+	}
+
+	return ae
+}
+
+// DigestHA1 returns the HA1 hash for the credentials, this is the value stored for digest users
+func DigestHA1(algorithm, username, realm, password string) string {
+	h, ok := digestHashes[algorithm]
+	if !ok {
+		return ""
+	}
+	return digestHash(h, username, realm, password)
+}
+
+// StaticDigestCredentials returns a DigestVerifier for a fixed map of usernames to passwords
+func StaticDigestCredentials(users map[string]string) DigestVerifier {
+	creds := digestCredentials(users)
+	return creds.verify
+}
+
+func (c digestCredentials) verify(_ context.Context, username, realm, algorithm string) (string, *Principal, error) {
+	password, ok := c[username]
+	if !ok {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	return DigestHA1(algorithm, username, realm, password), nil, nil
+}
+
+func digestHash(h func() hash.Hash, parts ...string) string {
+	hh := h()
+	hh.Write([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(hh.Sum(nil))
+}
+
+// parseAuthParams parses comma separated auth-param pairs, values may be quoted
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " \t,")
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var val string
+
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					b.WriteByte(s[i])
+					continue
+				}
+				if s[i] == '"' {
+					break
+				}
+				b.WriteByte(s[i])
+			}
+
+			val = b.String()
+
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			val = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[key] = val
+	}
+
+	return params
+}
+
+func newNonceManager(ttl time.Duration) *nonceManager {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	return &nonceManager{
+		secret: secret,
+		ttl:    ttl,
+		counts: make(map[string]uint64),
+	}
+}
+
+// issue returns a new nonce of the form base64(timestamp | random | hmac)
+func (m *nonceManager) issue() string {
+	buf := make([]byte, nonceSize)
+
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	rand.Read(buf[8:24])
+
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(buf[:24])
+	copy(buf[24:], mac.Sum(nil))
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// validate checks the nonce signature and age, and that the nonce count is greater than the
+// last count used; the count is not recorded, see use
+func (m *nonceManager) validate(nonce string, nc uint64) error {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != nonceSize {
+		return ErrDigestNonceInvalid
+	}
+
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(buf[:24])
+	if !hmac.Equal(mac.Sum(nil), buf[24:]) {
+		return ErrDigestNonceInvalid
+	}
+
+	if time.Since(nonceIssued(buf)) > m.ttl {
+		return ErrDigestNonceStale
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if nc <= m.counts[nonce] {
+		return ErrDigestNonceInvalid
+	}
+
+	return nil
+}
+
+// use records the nonce count of a validated nonce, it fails if the count has already been used
+func (m *nonceManager) use(nonce string, nc uint64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if time.Since(m.pruned) > noncePruneInterval {
+		m.prune()
+	}
+
+	if nc <= m.counts[nonce] {
+		return ErrDigestNonceInvalid
+	}
+
+	m.counts[nonce] = nc
+
+	return nil
+}
+
+// prune removes expired nonces, the lock must be held
+func (m *nonceManager) prune() {
+	m.pruned = time.Now()
+
+	for nonce := range m.counts {
+		buf, _ := base64.RawURLEncoding.DecodeString(nonce)
+
+		if time.Since(nonceIssued(buf)) > m.ttl {
+			delete(m.counts, nonce)
+		}
+	}
+}
+
+func nonceIssued(buf []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
+}
+
+// WithDigestRealm sets the digest realm, the realm is part of the HA1 hash
+func WithDigestRealm(realm string) DigestOption {
+	return func(a *digestAuthorizer) {
+		a.realm = realm
+	}
+}
+
+// WithDigestAlgorithms sets the offered digest algorithms in order of preference
+func WithDigestAlgorithms(algs ...string) DigestOption {
+	return func(a *digestAuthorizer) {
+		a.algorithms = make([]string, 0, len(algs))
+		for _, alg := range algs {
+			if _, ok := digestHashes[alg]; ok {
+				a.algorithms = append(a.algorithms, alg)
+			}
+		}
+	}
+}
+
+// WithDigestNonceTTL sets how long nonces are valid before they are stale
+func WithDigestNonceTTL(d time.Duration) DigestOption {
+	return func(a *digestAuthorizer) {
+		a.nonces.ttl = d
+	}
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// digestClient answers digest challenges for a user
+type digestClient struct {
+	user     string
+	password string
+	params   map[string]string
+}
+
+func (c *digestClient) challenge(t *testing.T, auth Authorizer) {
+	t.Helper()
+
+	_, err := auth(httptest.NewRequest("GET", "/", nil))
+
+	var ae *AuthError
+	if !errors.As(err, &ae) || len(ae.Challenges()) == 0 {
+		t.Fatalf("expected a challenge, got %v", err)
+	}
+
+	c.params = parseAuthParams(ae.Challenges()[0][len("Digest "):])
+}
+
+func (c *digestClient) request(method, uri string, nc uint64, response string) *http.Request {
+	ncs := fmt.Sprintf("%08x", nc)
+	cnonce := "0a4f113b"
+
+	if response == "" {
+		h := digestHashes[c.params["algorithm"]]
+		ha1 := DigestHA1(c.params["algorithm"], c.user, c.params["realm"], c.password)
+		ha2 := digestHash(h, method, uri)
+		response = digestHash(h, ha1, c.params["nonce"], ncs, cnonce, "auth", ha2)
+	}
+
+	r := httptest.NewRequest(method, uri, nil)
+	r.Header.Set("Authorization", fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, qop=auth, nc=%s, cnonce="%s", response="%s", opaque="%s"`,
+		c.user, c.params["realm"], c.params["nonce"], uri, c.params["algorithm"], ncs, cnonce, response, c.params["opaque"]))
+
+	return r
+}
+
+func TestDigestAuthorizer(t *testing.T) {
+	auth := DigestAuthorizer(StaticDigestCredentials(map[string]string{"user": "pass"}), WithDigestRealm("test"))
+
+	type step struct {
+		nc        uint64
+		uri       string
+		response  string
+		wantErr   error
+		wantStale bool
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		steps    []step
+	}{
+		{
+			name:     "valid with increasing counts",
+			password: "pass",
+			steps:    []step{{nc: 1}, {nc: 2}, {nc: 5}},
+		},
+		{
+			name:     "replayed count",
+			password: "pass",
+			steps:    []step{{nc: 1}, {nc: 1, wantErr: ErrDigestNonceInvalid}},
+		},
+		{
+			name:     "wrong password",
+			password: "wrong",
+			steps:    []step{{nc: 1, wantErr: ErrInvalidCredentials}},
+		},
+		{
+			name:     "forged high count does not lock out the client",
+			password: "pass",
+			steps: []step{
+				{nc: 1000, response: "00000000000000000000000000000000", wantErr: ErrInvalidCredentials},
+				{nc: 1},
+			},
+		},
+		{
+			name:     "uri mismatch",
+			password: "pass",
+			steps:    []step{{nc: 1, uri: "/other", wantErr: errors.New("digest uri mismatch")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &digestClient{user: "user", password: tt.password}
+			c.challenge(t, auth)
+
+			if c.params["algorithm"] != DigestSHA256 {
+				t.Errorf("algorithm = %s, want %s", c.params["algorithm"], DigestSHA256)
+			}
+
+			for i, s := range tt.steps {
+				r := c.request("GET", "/resource", s.nc, s.response)
+
+				// the signed uri differs from the requested uri
+				if s.uri != "" {
+					r.RequestURI = s.uri
+				}
+
+				ctx, err := auth(r)
+
+				switch {
+				case s.wantErr == nil && err != nil:
+					t.Fatalf("step %d: unexpected error %v", i, err)
+				case s.wantErr != nil && err == nil:
+					t.Fatalf("step %d: expected error %v", i, s.wantErr)
+				case s.wantErr != nil && !errors.Is(err, s.wantErr) && err.Error() != s.wantErr.Error():
+					t.Fatalf("step %d: error = %v, want %v", i, err, s.wantErr)
+				case err == nil:
+					if p := RequestPrincipal(ctx); p == nil || p.Subject != "user" || p.Type != "digest" {
+						t.Errorf("step %d: principal = %+v", i, p)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDigestNonceStale(t *testing.T) {
+	auth := DigestAuthorizer(StaticDigestCredentials(map[string]string{"user": "pass"}),
+		WithDigestNonceTTL(time.Millisecond), WithDigestAlgorithms(DigestMD5))
+
+	c := &digestClient{user: "user", password: "pass"}
+	c.challenge(t, auth)
+
+	time.Sleep(time.Millisecond * 5)
+
+	_, err := auth(c.request("GET", "/", 1, ""))
+	if !errors.Is(err, ErrDigestNonceStale) {
+		t.Fatalf("error = %v, want %v", err, ErrDigestNonceStale)
+	}
+
+	var ae *AuthError
+	errors.As(err, &ae)
+
+	if len(ae.Challenges()) != 1 || parseAuthParams(ae.Challenges()[0][len("Digest "):])["stale"] != "true" {
+		t.Errorf("challenges = %v, want a single stale MD5 challenge", ae.Challenges())
+	}
+}
+
+func TestNonceManager(t *testing.T) {
+	m := newNonceManager(time.Minute)
+	nonce := m.issue()
+
+	// the last character may only carry padding bits, so the decoded signature is modified
+	tampered, _ := base64.RawURLEncoding.DecodeString(nonce)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		nonce   string
+		nc      uint64
+		use     bool
+		wantErr error
+	}{
+		{name: "invalid encoding", nonce: "!", nc: 1, wantErr: ErrDigestNonceInvalid},
+		{name: "tampered", nonce: base64.RawURLEncoding.EncodeToString(tampered), nc: 1, wantErr: ErrDigestNonceInvalid},
+		{name: "validate does not record", nonce: nonce, nc: 2},
+		{name: "first use", nonce: nonce, nc: 1, use: true},
+		{name: "reused count", nonce: nonce, nc: 1, wantErr: ErrDigestNonceInvalid},
+		{name: "next count", nonce: nonce, nc: 2, use: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.validate(tt.nonce, tt.nc)
+			if err == nil && tt.use {
+				err = m.use(tt.nonce, tt.nc)
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// expired nonces are pruned
+	old := newNonceManager(time.Nanosecond)
+	n := old.issue()
+	old.counts[n] = 1
+	time.Sleep(time.Millisecond)
+
+	if err := old.use(old.issue(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := old.counts[n]; ok {
+		t.Error("expired nonce was not pruned")
+	}
+}
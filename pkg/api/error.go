@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/stoewer/go-strcase"
 )
@@ -24,6 +25,9 @@ type (
 		Status         int
 		ErrDescription string
 	}
+
+	// MultiError is a collection of errors
+	MultiError []error
 )
 
 var (
@@ -95,3 +99,22 @@ func StatusError(status int, e error) *Response {
 func StatusErrorf(status int, f string, args ...interface{}) *Response {
 	return Errorf(f, args...).WithStatus(status)
 }
+
+// Error returns the combined error messages
+func (m MultiError) Error() string {
+	msgs := make([]string, 0, len(m))
+
+	for _, e := range m {
+		msgs = append(msgs, e.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// ErrorOrNil returns nil if the collection is empty
+func (m MultiError) ErrorOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"strings"
+)
+
+type (
+	// RouteGroup is a set of routes that share a path prefix, default route options, middleware
+	// and authorizers; groups may be nested and inherit from their parent
+	RouteGroup struct {
+		server      *Server
+		prefix      string
+		opts        []RouteOption
+		middleware  []Middleware
+		authorizers []Authorizer
+	}
+)
+
+// Group returns a route group for the prefix under the api base path, the options are the
+// defaults for routes added to the group and may be overridden by the route
+func (s *Server) Group(prefix string, opts ...RouteOption) *RouteGroup {
+	return &RouteGroup{
+		server: s,
+		prefix: joinPath("", prefix),
+		opts:   opts,
+	}
+}
+
+// Group returns a nested route group, it inherits the prefix, options, middleware and
+// authorizers of the parent
+func (g *RouteGroup) Group(prefix string, opts ...RouteOption) *RouteGroup {
+	return &RouteGroup{
+		server:      g.server,
+		prefix:      joinPath(g.prefix, prefix),
+		opts:        append(append([]RouteOption{}, g.opts...), opts...),
+		middleware:  append([]Middleware{}, g.middleware...),
+		authorizers: append([]Authorizer{}, g.authorizers...),
+	}
+}
+
+// Use adds middleware to the group routes, it is called before any route middleware
+func (g *RouteGroup) Use(mw ...Middleware) *RouteGroup {
+	g.middleware = append(g.middleware, mw...)
+	return g
+}
+
+// Authorize adds authorizers to the group routes, unlike WithAuthorizers these are not replaced
+// by the route authorizers and all must succeed
+func (g *RouteGroup) Authorize(a ...Authorizer) *RouteGroup {
+	g.authorizers = append(g.authorizers, a...)
+	return g
+}
+
+// Prefix returns the group path prefix
+func (g *RouteGroup) Prefix() string {
+	return g.prefix
+}
+
+// AddRoute adds a route to the group, the path is relative to the group prefix
+func (g *RouteGroup) AddRoute(path string, handler interface{}, opts ...RouteOption) {
+	o := make([]RouteOption, 0, len(g.opts)+len(opts)+1)
+	o = append(o, g.opts...)
+	o = append(o, opts...)
+
+	// group middleware and authorizers are applied before those of the route
+	o = append(o, func(r *routeOption) {
+		r.middleware = append(append([]Middleware{}, g.middleware...), r.middleware...)
+		r.authorizers = append(append([]Authorizer{}, g.authorizers...), r.authorizers...)
+	})
+
+	g.server.AddRoute(joinPath(g.prefix, path), handler, o...)
+}
+
+// joinPath joins the path to the prefix with a single slash
+func joinPath(prefix, path string) string {
+	prefix = strings.TrimRight(prefix, "/")
+
+	if path == "" || path == "/" {
+		if prefix == "" {
+			return "/"
+		}
+		return prefix
+	}
+
+	return prefix + "/" + strings.TrimLeft(path, "/")
+}
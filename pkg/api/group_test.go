@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		prefix string
+		path   string
+		want   string
+	}{
+		{"", "", "/"},
+		{"", "/", "/"},
+		{"", "users", "/users"},
+		{"/admin", "", "/admin"},
+		{"/admin/", "/", "/admin"},
+		{"/admin", "users", "/admin/users"},
+		{"/admin/", "//users/{id}", "/admin/users/{id}"},
+	}
+
+	for _, tt := range tests {
+		if got := joinPath(tt.prefix, tt.path); got != tt.want {
+			t.Errorf("joinPath(%q, %q) = %q, want %q", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	calls := &callRecorder{}
+
+	authorizer := func(name string, err error) Authorizer {
+		return func(r *http.Request) (context.Context, error) {
+			calls.record(name)
+			return nil, err
+		}
+	}
+
+	handler := func(ctx context.Context) Responder {
+		calls.record("handler")
+		return NewResponse("ok")
+	}
+
+	s := NewServer(WithBasepath("/"))
+
+	admin := s.Group("/admin", WithMetadata("group", "admin")).
+		Use(calls.middleware("admin")).
+		Authorize(authorizer("admin-auth", nil))
+
+	admin.AddRoute("/", handler)
+	admin.AddRoute("/stats", handler,
+		WithMetadata("group", "stats"),
+		WithRouteMiddleware(calls.middleware("route")),
+		WithAuthorizers(authorizer("route-auth", nil)),
+	)
+
+	users := admin.Group("users").Use(calls.middleware("users"))
+	users.AddRoute("/{id}", handler)
+
+	locked := admin.Group("/locked").Authorize(authorizer("locked-auth", errors.New("locked")))
+	locked.AddRoute("", handler)
+
+	if users.Prefix() != "/admin/users" {
+		t.Errorf("prefix = %s, want /admin/users", users.Prefix())
+	}
+
+	tests := []struct {
+		path         string
+		wantStatus   int
+		wantCalls    []string
+		wantMetadata string
+	}{
+		{
+			path:         "/admin",
+			wantStatus:   http.StatusOK,
+			wantCalls:    []string{"admin", "admin-auth", "handler"},
+			wantMetadata: "admin",
+		},
+		{
+			path:         "/admin/stats",
+			wantStatus:   http.StatusOK,
+			wantCalls:    []string{"admin", "route", "admin-auth", "route-auth", "handler"},
+			wantMetadata: "stats",
+		},
+		{
+			path:         "/admin/users/1",
+			wantStatus:   http.StatusOK,
+			wantCalls:    []string{"admin", "users", "admin-auth", "handler"},
+			wantMetadata: "admin",
+		},
+		{
+			path:         "/admin/locked",
+			wantStatus:   http.StatusUnauthorized,
+			wantCalls:    []string{"admin", "admin-auth", "locked-auth"},
+			wantMetadata: "admin",
+		},
+	}
+
+	routes := make(map[string]RouteInfo)
+	for _, r := range s.Routes() {
+		routes[r.FullPath] = r
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			calls.calls = nil
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if !reflect.DeepEqual(calls.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls.calls, tt.wantCalls)
+			}
+		})
+	}
+
+	for path, want := range map[string]string{
+		"/admin":        "admin",
+		"/admin/stats":  "stats",
+		"/admin/{id}":   "",
+		"/admin/locked": "admin",
+	} {
+		if want == "" {
+			if _, ok := routes[path]; ok {
+				t.Errorf("route %s was registered outside of the nested group", path)
+			}
+			continue
+		}
+
+		if got := routes[path].Metadata["group"]; got != want {
+			t.Errorf("route %s metadata = %v, want %s", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// HealthCheck is a named component check, it should return an error if the component is unhealthy
+	HealthCheck func(ctx context.Context) error
+
+	// HealthCheckOption defines health check options
+	HealthCheckOption func(*healthCheck)
+
+	// HealthStatus is the aggregate status of a health report
+	HealthStatus string
+
+	// HealthReport is the aggregated result of the server health checks
+	HealthReport struct {
+		Status HealthStatus                  `json:"status"`
+		Checks map[string]*HealthCheckResult `json:"checks,omitempty"`
+	}
+
+	// HealthCheckResult is the result of a single health check
+	HealthCheckResult struct {
+		Status   HealthStatus `json:"status"`
+		Error    string       `json:"error,omitempty"`
+		Critical bool         `json:"critical"`
+		Duration string       `json:"duration"`
+	}
+
+	healthCheck struct {
+		name     string
+		check    HealthCheck
+		timeout  time.Duration
+		critical bool
+		liveness bool
+	}
+
+	health struct {
+		checks        map[string]*healthCheck
+		lock          sync.RWMutex
+		livenessPath  string
+		readinessPath string
+		draining      int32
+	}
+)
+
+const (
+	// HealthStatusOK is returned when all checks pass
+	HealthStatusOK HealthStatus = "ok"
+
+	// HealthStatusDegraded is returned when only non-critical checks fail
+	HealthStatusDegraded HealthStatus = "degraded"
+
+	// HealthStatusFailing is returned when a critical check fails or the server is shutting down
+	HealthStatusFailing HealthStatus = "failing"
+
+	// HealthCheckShutdown is the reserved check name reported by the readiness check once
+	// Shutdown begins
+	HealthCheckShutdown = "shutdown"
+
+	defaultHealthCheckTimeout = time.Second * 5
+)
+
+var (
+	// ErrServerShuttingDown is reported by the readiness check once Shutdown begins
+	ErrServerShuttingDown = errors.New("server is shutting down")
+)
+
+func newHealth() *health {
+	return &health{
+		checks: make(map[string]*healthCheck),
+	}
+}
+
+// AddHealthCheck registers a named health check, checks are part of the readiness probe
+// and optionally the liveness probe; registering an existing name replaces the check and
+// registering HealthCheckShutdown panics
+func (s *Server) AddHealthCheck(name string, check HealthCheck, opts ...HealthCheckOption) {
+	if name == HealthCheckShutdown {
+		panic(fmt.Sprintf("api: health check name %q is reserved", name))
+	}
+
+	hc := &healthCheck{
+		name:     name,
+		check:    check,
+		timeout:  defaultHealthCheckTimeout,
+		critical: true,
+	}
+
+	for _, o := range opts {
+		o(hc)
+	}
+
+	s.health.lock.Lock()
+	defer s.health.lock.Unlock()
+
+	s.health.checks[name] = hc
+}
+
+// RemoveHealthCheck removes the named health check
+func (s *Server) RemoveHealthCheck(name string) {
+	s.health.lock.Lock()
+	defer s.health.lock.Unlock()
+
+	delete(s.health.checks, name)
+}
+
+// Liveness runs the liveness checks and returns the report
+func (s *Server) Liveness(ctx context.Context) *HealthReport {
+	return s.health.run(ctx, true)
+}
+
+// Readiness runs the readiness checks and returns the report, readiness will
+// always fail once the server has started shutting down
+func (s *Server) Readiness(ctx context.Context) *HealthReport {
+	report := s.health.run(ctx, false)
+
+	if atomic.LoadInt32(&s.health.draining) == 1 {
+		report.Status = HealthStatusFailing
+		report.Checks[HealthCheckShutdown] = &HealthCheckResult{
+			Status:   HealthStatusFailing,
+			Error:    ErrServerShuttingDown.Error(),
+			Critical: true,
+			Duration: time.Duration(0).String(),
+		}
+	}
+
+	return report
+}
+
+func (s *Server) healthHandler(liveness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report *HealthReport
+
+		if liveness {
+			report = s.Liveness(r.Context())
+		} else {
+			report = s.Readiness(r.Context())
+		}
+
+		status := http.StatusOK
+		if report.Status == HealthStatusFailing {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+
+		s.WriteJSON(w, status, report)
+	}
+}
+
+func (h *health) run(ctx context.Context, liveness bool) *HealthReport {
+	h.lock.RLock()
+	checks := make([]*healthCheck, 0, len(h.checks))
+	for _, c := range h.checks {
+		if liveness && !c.liveness {
+			continue
+		}
+		checks = append(checks, c)
+	}
+	h.lock.RUnlock()
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+
+	report := &HealthReport{
+		Status: HealthStatusOK,
+		Checks: make(map[string]*HealthCheckResult),
+	}
+
+	results := make([]*HealthCheckResult, len(checks))
+
+	var wg sync.WaitGroup
+
+	for i, c := range checks {
+		wg.Add(1)
+
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+
+			results[i] = c.run(ctx)
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	for i, c := range checks {
+		res := results[i]
+
+		report.Checks[c.name] = res
+
+		if res.Status == HealthStatusOK {
+			continue
+		}
+
+		if c.critical {
+			report.Status = HealthStatusFailing
+		} else if report.Status == HealthStatusOK {
+			report.Status = HealthStatusDegraded
+		}
+	}
+
+	return report
+}
+
+func (c *healthCheck) run(ctx context.Context) *HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("health check panic: %v", r)
+			}
+		}()
+
+		done <- c.check(ctx)
+	}()
+
+	var err error
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("health check timed out after %s", c.timeout)
+	}
+
+	res := &HealthCheckResult{
+		Status:   HealthStatusOK,
+		Critical: c.critical,
+		Duration: time.Since(start).String(),
+	}
+
+	if err != nil {
+		res.Status = HealthStatusFailing
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// WithHealthPaths enables the liveness and readiness endpoints at the specified paths,
+// these are registered on the root router outside of the api base path; an empty path
+// disables the endpoint
+func WithHealthPaths(liveness, readiness string) Option {
+	return func(s *Server) {
+		s.health.livenessPath = liveness
+		s.health.readinessPath = readiness
+	}
+}
+
+// WithCheckTimeout sets the health check timeout, the check context is canceled after
+// the timeout and the check is reported as failing
+func WithCheckTimeout(d time.Duration) HealthCheckOption {
+	return func(c *healthCheck) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithCheckCritical sets the health check criticality, a failing non-critical check only
+// degrades the status and does not fail the probe
+func WithCheckCritical(critical bool) HealthCheckOption {
+	return func(c *healthCheck) {
+		c.critical = critical
+	}
+}
+
+// WithCheckLiveness includes the check in the liveness probe
+func WithCheckLiveness() HealthCheckOption {
+	return func(c *healthCheck) {
+		c.liveness = true
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthReport(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("unreachable") }
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	crash := func(ctx context.Context) error { panic("boom") }
+
+	type check struct {
+		name  string
+		check HealthCheck
+		opts  []HealthCheckOption
+	}
+
+	tests := []struct {
+		name       string
+		checks     []check
+		liveness   bool
+		draining   bool
+		wantStatus HealthStatus
+		wantChecks map[string]HealthStatus
+	}{
+		{
+			name:       "no checks",
+			wantStatus: HealthStatusOK,
+			wantChecks: map[string]HealthStatus{},
+		},
+		{
+			name:       "passing",
+			checks:     []check{{"db", ok, nil}, {"cache", ok, nil}},
+			wantStatus: HealthStatusOK,
+			wantChecks: map[string]HealthStatus{"db": HealthStatusOK, "cache": HealthStatusOK},
+		},
+		{
+			name:       "non-critical failure",
+			checks:     []check{{"db", ok, nil}, {"cache", fail, []HealthCheckOption{WithCheckCritical(false)}}},
+			wantStatus: HealthStatusDegraded,
+			wantChecks: map[string]HealthStatus{"db": HealthStatusOK, "cache": HealthStatusFailing},
+		},
+		{
+			name:       "critical failure",
+			checks:     []check{{"db", fail, nil}, {"cache", fail, []HealthCheckOption{WithCheckCritical(false)}}},
+			wantStatus: HealthStatusFailing,
+			wantChecks: map[string]HealthStatus{"db": HealthStatusFailing, "cache": HealthStatusFailing},
+		},
+		{
+			name:       "timeout",
+			checks:     []check{{"db", hang, []HealthCheckOption{WithCheckTimeout(10 * time.Millisecond)}}},
+			wantStatus: HealthStatusFailing,
+			wantChecks: map[string]HealthStatus{"db": HealthStatusFailing},
+		},
+		{
+			name:       "panic",
+			checks:     []check{{"db", crash, nil}},
+			wantStatus: HealthStatusFailing,
+			wantChecks: map[string]HealthStatus{"db": HealthStatusFailing},
+		},
+		{
+			name:       "liveness only runs liveness checks",
+			checks:     []check{{"db", fail, nil}, {"loop", ok, []HealthCheckOption{WithCheckLiveness()}}},
+			liveness:   true,
+			wantStatus: HealthStatusOK,
+			wantChecks: map[string]HealthStatus{"loop": HealthStatusOK},
+		},
+		{
+			name:       "draining",
+			checks:     []check{{"db", ok, nil}, {"server", ok, nil}},
+			draining:   true,
+			wantStatus: HealthStatusFailing,
+			wantChecks: map[string]HealthStatus{"db": HealthStatusOK, "server": HealthStatusOK, HealthCheckShutdown: HealthStatusFailing},
+		},
+		{
+			name:       "liveness while draining",
+			checks:     []check{{"loop", ok, []HealthCheckOption{WithCheckLiveness()}}},
+			liveness:   true,
+			draining:   true,
+			wantStatus: HealthStatusOK,
+			wantChecks: map[string]HealthStatus{"loop": HealthStatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithHealthPaths("/healthz", "/readyz"))
+
+			for _, c := range tt.checks {
+				s.AddHealthCheck(c.name, c.check, c.opts...)
+			}
+
+			if tt.draining {
+				atomic.StoreInt32(&s.health.draining, 1)
+			}
+
+			path := "/readyz"
+			if tt.liveness {
+				path = "/healthz"
+			}
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+			wantCode := http.StatusOK
+			if tt.wantStatus == HealthStatusFailing {
+				wantCode = http.StatusServiceUnavailable
+			}
+
+			if w.Code != wantCode {
+				t.Errorf("status code = %d, want %d", w.Code, wantCode)
+			}
+
+			var report HealthReport
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", report.Status, tt.wantStatus)
+			}
+
+			if len(report.Checks) != len(tt.wantChecks) {
+				t.Errorf("checks = %d, want %d", len(report.Checks), len(tt.wantChecks))
+			}
+
+			for name, want := range tt.wantChecks {
+				if res := report.Checks[name]; res == nil || res.Status != want {
+					t.Errorf("check %s = %+v, want %s", name, res, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveHealthCheck(t *testing.T) {
+	s := NewServer()
+
+	s.AddHealthCheck("db", func(ctx context.Context) error { return errors.New("down") })
+
+	if r := s.Readiness(context.Background()); r.Status != HealthStatusFailing {
+		t.Fatalf("status = %s, want %s", r.Status, HealthStatusFailing)
+	}
+
+	s.RemoveHealthCheck("db")
+
+	if r := s.Readiness(context.Background()); r.Status != HealthStatusOK || len(r.Checks) != 0 {
+		t.Errorf("report = %+v, want ok with no checks", r)
+	}
+}
+
+func TestReservedHealthCheck(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("registering the shutdown check did not panic")
+		}
+	}()
+
+	NewServer().AddHealthCheck(HealthCheckShutdown, func(ctx context.Context) error { return nil })
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type (
+	// ProtocolInfo describes the protocol a request was received on
+	ProtocolInfo struct {
+		// Proto is the request protocol, i.e. HTTP/1.1 or HTTP/2.0
+		Proto string `json:"proto"`
+
+		// Major is the protocol major version
+		Major int `json:"major"`
+
+		// Minor is the protocol minor version
+		Minor int `json:"minor"`
+
+		// TLS is true if the request was received over tls
+		TLS bool `json:"tls"`
+
+		// H2C is true if the request is HTTP/2 over cleartext
+		H2C bool `json:"h2c"`
+
+		// NegotiatedProtocol is the ALPN protocol negotiated during the tls handshake
+		NegotiatedProtocol string `json:"negotiated_protocol,omitempty"`
+
+		// TLSVersion is the tls version name
+		TLSVersion string `json:"tls_version,omitempty"`
+
+		// CipherSuite is the tls cipher suite name
+		CipherSuite string `json:"cipher_suite,omitempty"`
+	}
+
+	http2Options struct {
+		h2c                  bool
+		configured           bool
+		maxConcurrentStreams uint32
+		maxReadFrameSize     uint32
+		idleTimeout          time.Duration
+	}
+)
+
+var (
+	tlsVersionNames = map[uint16]string{
+		tls.VersionTLS10: "TLS1.0",
+		tls.VersionTLS11: "TLS1.1",
+		tls.VersionTLS12: "TLS1.2",
+		tls.VersionTLS13: "TLS1.3",
+	}
+)
+
+// configure applies the http2 options to the server, returning the handler to serve
+func (o *http2Options) configure(srv *http.Server, handler http.Handler) (http.Handler, error) {
+	if !o.h2c && !o.configured {
+		return handler, nil
+	}
+
+	h2 := &http2.Server{
+		MaxConcurrentStreams: o.maxConcurrentStreams,
+		MaxReadFrameSize:     o.maxReadFrameSize,
+		IdleTimeout:          o.idleTimeout,
+	}
+
+	if srv.TLSConfig != nil {
+		if err := http2.ConfigureServer(srv, h2); err != nil {
+			return nil, err
+		}
+		return handler, nil
+	}
+
+	if o.h2c {
+		return h2c.NewHandler(handler, h2), nil
+	}
+
+	return handler, nil
+}
+
+// RequestProtocol returns the protocol information for the current request
+func RequestProtocol(ctx context.Context) ProtocolInfo {
+	r, _ := Request(ctx)
+	if r == nil {
+		return ProtocolInfo{}
+	}
+
+	info := ProtocolInfo{
+		Proto: r.Proto,
+		Major: r.ProtoMajor,
+		Minor: r.ProtoMinor,
+		TLS:   r.TLS != nil,
+		H2C:   r.TLS == nil && r.ProtoMajor == 2,
+	}
+
+	if r.TLS != nil {
+		info.NegotiatedProtocol = r.TLS.NegotiatedProtocol
+		info.TLSVersion = tlsVersionNames[r.TLS.Version]
+		info.CipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+	}
+
+	return info
+}
+
+// WithH2C enables HTTP/2 over cleartext tcp for non-tls listeners, both prior knowledge
+// and HTTP/1.1 upgrade requests are supported
+func WithH2C() Option {
+	return func(s *Server) {
+		s.http2.h2c = true
+	}
+}
+
+// WithHTTP2MaxConcurrentStreams sets the maximum number of concurrent streams per client connection
+func WithHTTP2MaxConcurrentStreams(n uint32) Option {
+	return func(s *Server) {
+		s.http2.configured = true
+		s.http2.maxConcurrentStreams = n
+	}
+}
+
+// WithHTTP2MaxReadFrameSize sets the largest frame the server will read, valid values are
+// between 16k and 16M, zero uses the default
+func WithHTTP2MaxReadFrameSize(n uint32) Option {
+	return func(s *Server) {
+		s.http2.configured = true
+		s.http2.maxReadFrameSize = n
+	}
+}
+
+// WithHTTP2IdleTimeout sets how long an idle HTTP/2 connection is kept open
+func WithHTTP2IdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.http2.configured = true
+		s.http2.idleTimeout = d
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestH2C(t *testing.T) {
+	h2Client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		client  *http.Client
+		want    ProtocolInfo
+		wantErr bool
+	}{
+		{
+			name:   "http/1.1",
+			client: http.DefaultClient,
+			want:   ProtocolInfo{Proto: "HTTP/1.1", Major: 1, Minor: 1},
+		},
+		{
+			name:   "http/1.1 with h2c",
+			opts:   []Option{WithH2C()},
+			client: http.DefaultClient,
+			want:   ProtocolInfo{Proto: "HTTP/1.1", Major: 1, Minor: 1},
+		},
+		{
+			name:   "prior knowledge",
+			opts:   []Option{WithH2C(), WithHTTP2MaxConcurrentStreams(10)},
+			client: h2Client,
+			want:   ProtocolInfo{Proto: "HTTP/2.0", Major: 2, H2C: true},
+		},
+		{
+			name:    "prior knowledge without h2c",
+			client:  h2Client,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestListener(t)
+
+			s := NewServer(append(tt.opts, WithListener(l), WithBasepath("/"))...)
+
+			s.AddRoute("/proto", func(ctx context.Context) Responder {
+				return NewResponse(RequestProtocol(ctx))
+			})
+
+			if err := s.Serve(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Shutdown(context.Background())
+
+			resp, err := tt.client.Get("http://" + l.Addr().String() + "/proto")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+
+			var got ProtocolInfo
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("protocol = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTP2Configure(t *testing.T) {
+	handler := http.NewServeMux()
+
+	tests := []struct {
+		name        string
+		opts        http2Options
+		tls         bool
+		wantWrapped bool
+		wantH2      bool
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name:        "h2c",
+			opts:        http2Options{h2c: true},
+			wantWrapped: true,
+		},
+		{
+			name: "configured without tls",
+			opts: http2Options{configured: true, idleTimeout: time.Minute},
+		},
+		{
+			name:   "configured with tls",
+			opts:   http2Options{configured: true, maxReadFrameSize: 1 << 20},
+			tls:    true,
+			wantH2: true,
+		},
+		{
+			name:   "h2c with tls",
+			opts:   http2Options{h2c: true},
+			tls:    true,
+			wantH2: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &http.Server{}
+			if tt.tls {
+				srv.TLSConfig = &tls.Config{}
+			}
+
+			h, err := tt.opts.configure(srv, handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if wrapped := h != handler; wrapped != tt.wantWrapped {
+				t.Errorf("wrapped = %v, want %v", wrapped, tt.wantWrapped)
+			}
+
+			if _, ok := srv.TLSNextProto[http2.NextProtoTLS]; ok != tt.wantH2 {
+				t.Errorf("h2 configured = %v, want %v", ok, tt.wantH2)
+			}
+		})
+	}
+}
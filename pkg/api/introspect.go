@@ -0,0 +1,241 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type (
+	// RouteInfo describes a route registration
+	RouteInfo struct {
+		// Path is the route path relative to the api base path
+		Path string `json:"path"`
+
+		// FullPath is the route path including the api base path
+		FullPath string `json:"full_path"`
+
+		// Methods are the http methods the route handles
+		Methods []string `json:"methods"`
+
+		// Handler is the package qualified name of the route handler
+		Handler string `json:"handler"`
+
+		// Params is the type name of the route params, if any
+		Params string `json:"params,omitempty"`
+
+		// ParamFields are the bound fields of the params type
+		ParamFields []RouteParam `json:"param_fields,omitempty"`
+
+		// Validate is true when the params are validated
+		Validate bool `json:"validate"`
+
+		// Authorizers are the names of the route authorizers
+		Authorizers []string `json:"authorizers,omitempty"`
+
+		// Cache is true when responses are cached
+		Cache bool `json:"cache"`
+
+		// Timeout is the route handler timeout, if any
+		Timeout string `json:"timeout,omitempty"`
+
+		// Browser is true when unsafe methods require a valid csrf token
+		Browser bool `json:"browser,omitempty"`
+
+		// RateLimited is true when server or route rate limits apply
+		RateLimited bool `json:"rate_limited,omitempty"`
+
+		// Priority is the route priority class used when the server is overloaded
+		Priority Priority `json:"priority"`
+
+		// Introduced is the version the route was introduced in
+		Introduced string `json:"introduced,omitempty"`
+
+		// Removed is the version the route was removed in
+		Removed string `json:"removed,omitempty"`
+
+		// VersionRange is the range of versions the route handles
+		VersionRange string `json:"version_range,omitempty"`
+
+		// Deprecated is true when the route is deprecated
+		Deprecated bool `json:"deprecated,omitempty"`
+
+		// Sunset is when the deprecated route is removed, if set
+		Sunset *time.Time `json:"sunset,omitempty"`
+
+		// Changes are the version changes of the route with their descriptions
+		Changes []string `json:"changes,omitempty"`
+
+		// Metadata is the route metadata
+		Metadata map[string]interface{} `json:"metadata,omitempty"`
+	}
+
+	// RouteParam describes a field of the route params type, the name is the json name used to
+	// bind the field
+	RouteParam struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+)
+
+var (
+	closureSuffix = regexp.MustCompile(`(\.func\d+|-fm)+$`)
+)
+
+// routeInfo creates the route info for the registration
+func (s *Server) routeInfo(path string, handler interface{}, opt *routeOption) RouteInfo {
+	info := RouteInfo{
+		Path:         path,
+		FullPath:     strings.TrimRight(s.basePath, "/") + path,
+		Methods:      append([]string{}, opt.methods...),
+		Handler:      funcName(handler),
+		Validate:     opt.validate,
+		Cache:        opt.cache,
+		Browser:      opt.browser,
+		RateLimited:  len(s.rateLimits) > 0 || len(opt.rateLimits) > 0,
+		Priority:     opt.priority,
+		Introduced:   opt.introduced,
+		Removed:      opt.removed,
+		VersionRange: opt.versionRange,
+		Deprecated:   opt.deprecation != nil,
+		Metadata:     make(map[string]interface{}),
+	}
+
+	if opt.params != nil {
+		pt := reflect.TypeOf(opt.params)
+		if pt.Kind() == reflect.Ptr {
+			pt = pt.Elem()
+		}
+
+		info.Params = pt.String()
+		info.ParamFields = paramFields(pt)
+	}
+
+	for _, a := range opt.authorizers {
+		if a != nil {
+			info.Authorizers = append(info.Authorizers, funcName(a))
+		}
+	}
+
+	if opt.timeout > 0 {
+		info.Timeout = opt.timeout.String()
+	}
+
+	if opt.deprecation != nil && !opt.deprecation.Sunset.IsZero() {
+		sunset := opt.deprecation.Sunset
+		info.Sunset = &sunset
+	}
+
+	for _, c := range newVersionPipeline(opt.changes) {
+		info.Changes = append(info.Changes, c.version.String()+": "+c.Description)
+	}
+
+	for k, v := range opt.metadata {
+		info.Metadata[k] = v
+	}
+
+	return info
+}
+
+// paramFields returns the bound fields of the params struct
+func paramFields(t reflect.Type) []RouteParam {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make([]RouteParam, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		fields = append(fields, RouteParam{
+			Name: name,
+			Type: f.Type.String(),
+		})
+	}
+
+	return fields
+}
+
+// funcName returns the package qualified name of the function, closures are named for the
+// function that created them and method values for their method
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return reflect.TypeOf(fn).String()
+	}
+
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil {
+		return v.Type().String()
+	}
+
+	name := f.Name()
+
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+// Routes returns the route registrations in the order they were added
+func (s *Server) Routes() []RouteInfo {
+	s.routeLock.Lock()
+	defer s.routeLock.Unlock()
+
+	return append([]RouteInfo{}, s.routeInfos...)
+}
+
+// routesHandler renders the route registrations as json
+func (s *Server) routesHandler(w http.ResponseWriter, r *http.Request) {
+	if auth := AllOf(s.routesAuthorizers...); auth != nil {
+		if _, err := auth(r); err != nil {
+			if resp, ok := err.(Responder); ok {
+				if err := resp.Write(w, r); err != nil {
+					s.log.Error(err.Error())
+					s.WriteError(w, http.StatusInternalServerError, err)
+				}
+			} else {
+				s.log.Error(err.Error())
+				s.WriteError(w, http.StatusUnauthorized, err)
+			}
+			return
+		}
+	}
+
+	s.WriteJSON(w, http.StatusOK, s.Routes(), true)
+}
+
+// WithRoutesEndpoint enables an endpoint at the path that lists the route registrations as json,
+// it is registered on the root router outside of the api base path; the authorizers should be
+// used to restrict access in production
+func WithRoutesEndpoint(path string, auth ...Authorizer) Option {
+	return func(s *Server) {
+		s.routesPath = path
+		s.routesAuthorizers = auth
+	}
+}
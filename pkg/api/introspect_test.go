@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+type listItemsParams struct {
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor,omitempty"`
+	Filter string `json:"-"`
+	Sort   string
+	hidden string
+}
+
+func listItems(ctx context.Context, params *listItemsParams) Responder {
+	return NewResponse(params.hidden)
+}
+
+func TestRouteInfo(t *testing.T) {
+	s := NewServer(WithBasepath("/api"))
+
+	s.AddRoute("/items", listItems,
+		WithParams(&listItemsParams{}),
+		WithAuthorizers(testAuthorizer("a", nil)),
+		WithTimeout(time.Second),
+		WithMetadata("owner", "items"),
+	)
+	s.AddRoute("/items/{id}", func(ctx context.Context) Responder {
+		return NewResponse(nil)
+	}, WithMethod(http.MethodDelete))
+
+	routes := s.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("routes = %d, want 2", len(routes))
+	}
+
+	info := routes[0]
+
+	if info.Path != "/items" || info.FullPath != "/api/items" {
+		t.Errorf("path = %s %s, want /items /api/items", info.Path, info.FullPath)
+	}
+
+	if info.Handler != "api.listItems" {
+		t.Errorf("handler = %s, want api.listItems", info.Handler)
+	}
+
+	if info.Params != "api.listItemsParams" {
+		t.Errorf("params = %s, want api.listItemsParams", info.Params)
+	}
+
+	wantFields := []RouteParam{
+		{Name: "limit", Type: "int"},
+		{Name: "cursor", Type: "string"},
+		{Name: "Sort", Type: "string"},
+	}
+	if !reflect.DeepEqual(info.ParamFields, wantFields) {
+		t.Errorf("param fields = %+v, want %+v", info.ParamFields, wantFields)
+	}
+
+	if len(info.Authorizers) != 1 || info.Timeout != "1s" || info.Metadata["owner"] != "items" {
+		t.Errorf("info = %+v, want the route options", info)
+	}
+
+	if got := routes[1].Methods; !reflect.DeepEqual(got, []string{http.MethodDelete}) {
+		t.Errorf("methods = %v, want [DELETE]", got)
+	}
+
+	// changes to the returned routes are not kept
+	routes[0].Path = "/changed"
+	if s.Routes()[0].Path != "/items" {
+		t.Error("Routes() returned the registrations")
+	}
+}
+
+func TestRoutesEndpoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		auth          []Authorizer
+		wantStatus    int
+		wantChallenge string
+	}{
+		{
+			name:       "open",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "authorized",
+			auth:       []Authorizer{testAuthorizer("a", nil)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:          "auth error",
+			auth:          []Authorizer{testAuthorizer("a", NewAuthError(http.StatusForbidden, `Bearer error="insufficient_scope"`, ErrForbidden))},
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer error="insufficient_scope"`,
+		},
+		{
+			name:       "error",
+			auth:       []Authorizer{testAuthorizer("a", errors.New("denied"))},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(
+				WithBasepath("/api"),
+				WithLog(&log.Logger{Handler: discard.Default}),
+				WithRoutesEndpoint("/routes", tt.auth...),
+			)
+
+			s.AddRoute("/items", listItems)
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/routes", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantChallenge)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var routes []RouteInfo
+			if err := json.NewDecoder(w.Body).Decode(&routes); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(routes) != 1 || routes[0].FullPath != "/api/items" {
+				t.Errorf("routes = %+v, want the items route", routes)
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+type (
+	// JWKS is a KeySet backed by a JSON Web Key Set loaded from a file or url; the keys are
+	// cached and refreshed periodically, and an unknown key id triggers a rate limited refresh
+	// so that key rotation is picked up without a restart
+	JWKS struct {
+		source     string
+		client     *http.Client
+		log        log.Interface
+		refresh    time.Duration
+		minRefresh time.Duration
+		keys       map[string]*jwk
+		fetched    time.Time
+		attempted  time.Time
+		call       *jwksCall
+		lock       sync.Mutex
+	}
+
+	// JWKSOption defines JWKS options
+	JWKSOption func(*JWKS)
+
+	// jwksCall is an in-flight load shared by concurrent callers
+	jwksCall struct {
+		done chan struct{}
+		err  error
+	}
+
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		K   string `json:"k"`
+
+		key interface{}
+	}
+
+	jwkSet struct {
+		Keys []*jwk `json:"keys"`
+	}
+)
+
+const (
+	defaultJWKSRefresh    = time.Hour
+	defaultJWKSMinRefresh = time.Minute
+	defaultJWKSTimeout    = time.Second * 10
+)
+
+var (
+	// ErrJWKSInvalid is returned when the key set cannot be parsed
+	ErrJWKSInvalid = errors.New("invalid json web key set")
+)
+
+// NewJWKS returns a JWKS key set for the source, which is either an http(s) url or a file path
+func NewJWKS(source string, opts ...JWKSOption) *JWKS {
+	ks := &JWKS{
+		source:     source,
+		client:     &http.Client{Timeout: defaultJWKSTimeout},
+		log:        log.Log,
+		refresh:    defaultJWKSRefresh,
+		minRefresh: defaultJWKSMinRefresh,
+	}
+
+	for _, o := range opts {
+		o(ks)
+	}
+
+	return ks
+}
+
+// Key implements the KeySet interface; loads, including failed loads, are limited to one per
+// minimum refresh interval and load errors are logged rather than returned
+func (ks *JWKS) Key(ctx context.Context, kid string, alg string) (interface{}, error) {
+	ks.lock.Lock()
+	stale := ks.keys == nil || time.Since(ks.fetched) > ks.refresh
+	canLoad := ks.canLoad()
+	ks.lock.Unlock()
+
+	if stale && canLoad {
+		ks.reload(ctx)
+		canLoad = false
+	}
+
+	ks.lock.Lock()
+	k, ok := ks.lookup(kid, alg)
+	canLoad = canLoad && ks.canLoad()
+	ks.lock.Unlock()
+
+	if !ok && canLoad {
+		// the key may have been rotated
+		ks.reload(ctx)
+
+		ks.lock.Lock()
+		k, ok = ks.lookup(kid, alg)
+		ks.lock.Unlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, kid)
+	}
+
+	return k.key, nil
+}
+
+// canLoad returns true if a load may be started or joined, the lock must be held
+func (ks *JWKS) canLoad() bool {
+	return ks.call != nil || ks.attempted.IsZero() || time.Since(ks.attempted) > ks.minRefresh
+}
+
+// Refresh forces the key set to be reloaded
+func (ks *JWKS) Refresh(ctx context.Context) error {
+	return ks.reload(ctx)
+}
+
+// reload loads the key set outside of the lock, concurrent callers share a single load and the
+// keys are replaced only on success; errors are logged
+func (ks *JWKS) reload(ctx context.Context) error {
+	ks.lock.Lock()
+
+	if c := ks.call; c != nil {
+		ks.lock.Unlock()
+
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	c := &jwksCall{done: make(chan struct{})}
+	ks.call = c
+	ks.attempted = time.Now()
+	ks.lock.Unlock()
+
+	// the load is shared so it is not canceled with the caller's request
+	keys, err := ks.load(context.Background())
+
+	if err != nil {
+		ks.log.Errorf("jwks load failed: %s", err)
+	}
+
+	ks.lock.Lock()
+	if err == nil {
+		ks.keys = keys
+		ks.fetched = time.Now()
+	}
+	ks.call = nil
+	ks.lock.Unlock()
+
+	c.err = err
+	close(c.done)
+
+	return err
+}
+
+func (ks *JWKS) lookup(kid string, alg string) (*jwk, bool) {
+	if kid != "" {
+		k, ok := ks.keys[kid]
+		if ok && k.Alg != "" && k.Alg != alg {
+			return nil, false
+		}
+		return k, ok
+	}
+
+	// tokens without a kid can only use a single key set
+	if len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, k.Alg == "" || k.Alg == alg
+		}
+	}
+
+	return nil, false
+}
+
+// load fetches and parses the key set
+func (ks *JWKS) load(ctx context.Context) (map[string]*jwk, error) {
+	var data []byte
+	var err error
+
+	if strings.HasPrefix(ks.source, "http://") || strings.HasPrefix(ks.source, "https://") {
+		data, err = ks.fetch(ctx)
+	} else {
+		data, err = ioutil.ReadFile(strings.TrimPrefix(ks.source, "file://"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJWKS(data)
+}
+
+func (ks *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, ks.source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks fetch failed: %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseJWKS parses a JSON Web Key Set, keys that are not for signatures, are of unsupported
+// types or curves, or are invalid are ignored
+func parseJWKS(data []byte) (map[string]*jwk, error) {
+	set := jwkSet{}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJWKSInvalid, err)
+	}
+
+	keys := make(map[string]*jwk)
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil || key == nil {
+			continue
+		}
+
+		k.key = key
+		keys[k.Kid] = k
+	}
+
+	return keys, nil
+}
+
+// publicKey returns the verification key, or nil if the key type is not supported
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := jwkInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := jwkInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := jwkInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := jwkInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+
+	return nil, nil
+}
+
+func jwkInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// WithJWKSClient sets the http client used to fetch the key set, the default client has a ten
+// second timeout
+func WithJWKSClient(c *http.Client) JWKSOption {
+	return func(ks *JWKS) {
+		if c != nil {
+			ks.client = c
+		}
+	}
+}
+
+// WithJWKSLog sets the logger for key set load errors
+func WithJWKSLog(l log.Interface) JWKSOption {
+	return func(ks *JWKS) {
+		if l != nil {
+			ks.log = l
+		}
+	}
+}
+
+// WithJWKSRefresh sets how long the key set is cached before it is refreshed, the default is one hour
+func WithJWKSRefresh(d time.Duration) JWKSOption {
+	return func(ks *JWKS) {
+		ks.refresh = d
+	}
+}
+
+// WithJWKSMinRefresh sets the minimum interval between refreshes triggered by unknown key ids
+func WithJWKSMinRefresh(d time.Duration) JWKSOption {
+	return func(ks *JWKS) {
+		ks.minRefresh = d
+	}
+}
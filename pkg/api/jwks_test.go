@@ -0,0 +1,319 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+// testJWKSServer serves the current key set and counts the requests
+type testJWKSServer struct {
+	*httptest.Server
+
+	keys     []map[string]string
+	status   int
+	delay    time.Duration
+	requests int32
+	lock     sync.Mutex
+}
+
+func newTestJWKSServer() *testJWKSServer {
+	s := &testJWKSServer{status: http.StatusOK}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+
+		s.lock.Lock()
+		status, delay, keys := s.status, s.delay, s.keys
+		s.lock.Unlock()
+
+		time.Sleep(delay)
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+
+	return s
+}
+
+func (s *testJWKSServer) set(status int, keys ...map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.status = status
+	s.keys = keys
+}
+
+func (s *testJWKSServer) count() int {
+	return int(atomic.LoadInt32(&s.requests))
+}
+
+func newTestECKey(t *testing.T, kid string) (*ecdsa.PrivateKey, map[string]string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"use": "sig",
+		"alg": "ES256",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func newTestJWKS(source string, opts ...JWKSOption) *JWKS {
+	return NewJWKS(source, append([]JWKSOption{WithJWKSLog(&log.Logger{Handler: discard.Default})}, opts...)...)
+}
+
+func TestJWKSAuthorizer(t *testing.T) {
+	srv := newTestJWKSServer()
+	defer srv.Close()
+
+	key1, jwk1 := newTestECKey(t, "key-1")
+	key2, jwk2 := newTestECKey(t, "key-2")
+
+	srv.set(http.StatusOK, jwk1)
+
+	ks := newTestJWKS(srv.URL, WithJWKSMinRefresh(0))
+	auth := JWTAuthorizer(WithJWTKeySet(ks))
+
+	claims := map[string]interface{}{"sub": "user", "exp": time.Now().Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name    string
+		rotate  func()
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "known key",
+			token: signTestJWT(t, "key-1", key1, claims),
+		},
+		{
+			name: "rotated key is fetched",
+			rotate: func() {
+				srv.set(http.StatusOK, jwk1, jwk2)
+			},
+			token: signTestJWT(t, "key-2", key2, claims),
+		},
+		{
+			name: "removed key",
+			rotate: func() {
+				srv.set(http.StatusOK, jwk2)
+			},
+			token:   signTestJWT(t, "key-3", key1, claims),
+			wantErr: ErrKeyNotFound,
+		},
+		{
+			name:    "wrong key",
+			token:   signTestJWT(t, "key-2", key1, claims),
+			wantErr: ErrTokenSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rotate != nil {
+				tt.rotate()
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			_, err := auth(r)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKSRefreshRateLimit(t *testing.T) {
+	srv := newTestJWKSServer()
+	defer srv.Close()
+
+	_, jwk1 := newTestECKey(t, "key-1")
+
+	tests := []struct {
+		name         string
+		status       int
+		kids         []string
+		wantRequests int
+		wantErr      error
+	}{
+		{
+			name:         "unknown kids refresh once",
+			status:       http.StatusOK,
+			kids:         []string{"key-1", "unknown", "unknown", "key-1"},
+			wantRequests: 1,
+		},
+		{
+			name:         "failed initial load is rate limited",
+			status:       http.StatusInternalServerError,
+			kids:         []string{"key-1", "key-1", "key-1"},
+			wantRequests: 1,
+			wantErr:      ErrKeyNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.set(tt.status, jwk1)
+			start := srv.count()
+
+			ks := newTestJWKS(srv.URL)
+
+			var err error
+			for _, kid := range tt.kids {
+				_, err = ks.Key(context.Background(), kid, "ES256")
+			}
+
+			if got := srv.count() - start; got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), srv.URL) {
+					t.Errorf("error %q exposes the key set url", err)
+				}
+			}
+		})
+	}
+}
+
+func TestJWKSConcurrentLoad(t *testing.T) {
+	srv := newTestJWKSServer()
+	defer srv.Close()
+
+	_, jwk1 := newTestECKey(t, "key-1")
+	srv.set(http.StatusOK, jwk1)
+
+	srv.lock.Lock()
+	srv.delay = time.Millisecond * 100
+	srv.lock.Unlock()
+
+	ks := newTestJWKS(srv.URL)
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ks.Key(context.Background(), "key-1", "ES256")
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+
+	if n := srv.count(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	_, ec := newTestECKey(t, "ec")
+
+	tests := []struct {
+		name     string
+		data     string
+		wantKids []string
+		wantErr  bool
+	}{
+		{
+			name:    "invalid json",
+			data:    "{",
+			wantErr: true,
+		},
+		{
+			name:     "unsupported keys are skipped",
+			data:     `{"keys":[{"kty":"EC","kid":"p192","crv":"P-192","x":"AA","y":"AA"},{"kty":"OKP","kid":"x25519","crv":"X25519","x":"AA"},{"kty":"oct","kid":"enc","use":"enc","k":"AA"},{"kty":"unknown","kid":"unknown"}]}`,
+			wantKids: []string{},
+		},
+		{
+			name:     "invalid keys are skipped",
+			data:     `{"keys":[{"kty":"EC","kid":"bad","crv":"P-256","x":"AA","y":"AA"},{"kty":"oct","kid":"hmac","k":"c2VjcmV0"}]}`,
+			wantKids: []string{"hmac"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseJWKS([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(keys) != len(tt.wantKids) {
+				t.Errorf("keys = %d, want %d", len(keys), len(tt.wantKids))
+			}
+
+			for _, kid := range tt.wantKids {
+				if _, ok := keys[kid]; !ok {
+					t.Errorf("key %s missing", kid)
+				}
+			}
+		})
+	}
+
+	// a valid key is kept alongside an unsupported one
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []interface{}{ec, map[string]string{"kty": "EC", "kid": "p192", "crv": "P-192"}},
+	})
+
+	keys, err := parseJWKS(data)
+	if err != nil || keys["ec"] == nil {
+		t.Errorf("parseJWKS() = %v, %v, want the ec key", keys, err)
+	}
+}
@@ -0,0 +1,548 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	// register the sha2 hash functions
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+type (
+	// Claims are the verified claims of a JWT
+	Claims struct {
+		Issuer    string      `json:"iss,omitempty"`
+		Subject   string      `json:"sub,omitempty"`
+		Audience  Audience    `json:"aud,omitempty"`
+		ExpiresAt NumericDate `json:"exp,omitempty"`
+		NotBefore NumericDate `json:"nbf,omitempty"`
+		IssuedAt  NumericDate `json:"iat,omitempty"`
+		ID        string      `json:"jti,omitempty"`
+		Scope     string      `json:"scope,omitempty"`
+
+		raw map[string]interface{}
+	}
+
+	// Audience is the JWT aud claim, which may be a string or an array of strings
+	Audience []string
+
+	// NumericDate is a JWT date claim in seconds since the epoch
+	NumericDate int64
+
+	// KeySet returns the verification key for a token key id and algorithm; HMAC keys are
+	// []byte and asymmetric keys are *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+	KeySet interface {
+		Key(ctx context.Context, kid string, alg string) (interface{}, error)
+	}
+
+	// StaticKeySet is a fixed KeySet mapping key ids to keys, the empty key id is used
+	// for tokens without a kid header
+	StaticKeySet map[string]interface{}
+
+	// JWTOption defines jwt authorizer options
+	JWTOption func(*jwtAuthorizer)
+
+	jwtAuthorizer struct {
+		keys       KeySet
+		algs       map[string]bool
+		issuers    []string
+		audience   []string
+		skew       time.Duration
+		realm      string
+		requireExp bool
+		rolesClaim string
+		permsClaim string
+		now        func() time.Time
+	}
+
+	jwtHeader struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+)
+
+const (
+	defaultJWTClockSkew = time.Minute
+	defaultAuthRealm    = "api"
+)
+
+var (
+	// ErrTokenMissing is returned when the request has no bearer token
+	ErrTokenMissing = fmt.Errorf("%w: bearer token", ErrCredentialsMissing)
+
+	// ErrTokenMalformed is returned when the token is not a valid JWT
+	ErrTokenMalformed = errors.New("token malformed")
+
+	// ErrTokenSignature is returned when the token signature is invalid
+	ErrTokenSignature = errors.New("token signature invalid")
+
+	// ErrTokenAlgorithm is returned when the token algorithm is not allowed
+	ErrTokenAlgorithm = errors.New("token algorithm not allowed")
+
+	// ErrTokenExpired is returned when the token has expired
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrTokenExpirationMissing is returned when the token has no exp claim and one is required
+	ErrTokenExpirationMissing = errors.New("token expiration missing")
+
+	// ErrTokenNotValidYet is returned when the token nbf is in the future
+	ErrTokenNotValidYet = errors.New("token not valid yet")
+
+	// ErrTokenIssuer is returned when the token issuer is not trusted
+	ErrTokenIssuer = errors.New("token issuer invalid")
+
+	// ErrTokenAudience is returned when the token audience does not match
+	ErrTokenAudience = errors.New("token audience invalid")
+
+	// ErrKeyNotFound is returned by a KeySet when the key does not exist
+	ErrKeyNotFound = errors.New("key not found")
+
+	// jwtAlgorithms are the supported signature algorithms
+	jwtAlgorithms = map[string]crypto.Hash{
+		"HS256": crypto.SHA256,
+		"HS384": crypto.SHA384,
+		"HS512": crypto.SHA512,
+		"RS256": crypto.SHA256,
+		"RS384": crypto.SHA384,
+		"RS512": crypto.SHA512,
+		"PS256": crypto.SHA256,
+		"PS384": crypto.SHA384,
+		"PS512": crypto.SHA512,
+		"ES256": crypto.SHA256,
+		"ES384": crypto.SHA384,
+		"ES512": crypto.SHA512,
+		"EdDSA": 0,
+	}
+
+	contextKeyClaims = contextKey("claims")
+)
+
+// JWTAuthorizer returns an Authorizer that verifies the request bearer token and adds the
+// claims to the context; failures return a 401 with a Bearer WWW-Authenticate challenge
+func JWTAuthorizer(opts ...JWTOption) Authorizer {
+	a := &jwtAuthorizer{
+		skew:       defaultJWTClockSkew,
+		realm:      defaultAuthRealm,
+		requireExp: true,
+		rolesClaim: "roles",
+		permsClaim: "permissions",
+		now:        time.Now,
+		algs:       make(map[string]bool),
+	}
+
+	for alg := range jwtAlgorithms {
+		a.algs[alg] = true
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a.authorize
+}
+
+func (a *jwtAuthorizer) authorize(r *http.Request) (context.Context, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, NewAuthError(
+			http.StatusUnauthorized,
+			authChallenge("Bearer", map[string]string{"realm": a.realm}),
+			ErrTokenMissing)
+	}
+
+	claims, err := a.verify(r.Context(), token)
+	if err != nil {
+		return nil, NewAuthError(
+			http.StatusUnauthorized,
+			authChallenge("Bearer", map[string]string{
+				"realm":             a.realm,
+				"error":             "invalid_token",
+				"error_description": err.Error(),
+			}),
+			err)
+	}
+
+	ctx := context.WithValue(r.Context(), contextKeyClaims, claims)
+
+	return ContextWithPrincipal(ctx, &Principal{
+		Subject:     claims.Subject,
+		Type:        "jwt",
+		Scopes:      claims.Scopes(),
+		Roles:       claims.Strings(a.rolesClaim),
+		Permissions: claims.Strings(a.permsClaim),
+		Attributes:  claims.raw,
+	}), nil
+}
+
+// verify parses and verifies the token, returning the claims
+func (a *jwtAuthorizer) verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	hdr := jwtHeader{}
+	if err := jwtDecodeSegment(parts[0], &hdr); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	if !a.algs[hdr.Alg] {
+		return nil, ErrTokenAlgorithm
+	}
+
+	if a.keys == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	// key set errors may contain internal details and are not returned to the client
+	key, err := a.keys.Key(ctx, hdr.Kid, hdr.Alg)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	if err := jwtVerifySignature(hdr.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := jwtDecodeSegment(parts[1], claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	if err := jwtDecodeSegment(parts[1], &claims.raw); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	now := a.now()
+
+	if claims.ExpiresAt == 0 && a.requireExp {
+		return nil, ErrTokenExpirationMissing
+	}
+
+	if claims.ExpiresAt != 0 && now.Add(-a.skew).After(claims.ExpiresAt.Time()) {
+		return nil, ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(a.skew).Before(claims.NotBefore.Time()) {
+		return nil, ErrTokenNotValidYet
+	}
+
+	if len(a.issuers) > 0 && !stringsContain(a.issuers, claims.Issuer) {
+		return nil, ErrTokenIssuer
+	}
+
+	if len(a.audience) > 0 {
+		ok := false
+		for _, aud := range claims.Audience {
+			if stringsContain(a.audience, aud) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, ErrTokenAudience
+		}
+	}
+
+	return claims, nil
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	return ""
+}
+
+func jwtDecodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
+
+// jwtVerifySignature verifies the signature for the algorithm, the key type must match the
+// algorithm family to prevent key confusion
+func jwtVerifySignature(alg string, key interface{}, signed, sig []byte) error {
+	hash := jwtAlgorithms[alg]
+
+	var digest []byte
+	if hash != 0 && alg[:2] != "HS" {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	switch alg[:2] {
+	case "HS":
+		k, ok := key.([]byte)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrTokenSignature
+		}
+
+	case "RS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return ErrTokenSignature
+		}
+
+	case "PS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		if err := rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return ErrTokenSignature
+		}
+
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrTokenSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrTokenSignature
+		}
+
+	case "Ed":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		if !ed25519.Verify(k, signed, sig) {
+			return ErrTokenSignature
+		}
+
+	default:
+		return ErrTokenAlgorithm
+	}
+
+	return nil
+}
+
+// UnmarshalJSON handles the string or array forms of the aud claim
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var l []string
+	if err := json.Unmarshal(data, &l); err != nil {
+		return err
+	}
+
+	*a = Audience(l)
+
+	return nil
+}
+
+// UnmarshalJSON handles integer and fractional dates
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+
+	*d = NumericDate(f)
+
+	return nil
+}
+
+// Time returns the date as a time
+func (d NumericDate) Time() time.Time {
+	return time.Unix(int64(d), 0)
+}
+
+// Get returns the raw claim value
+func (c *Claims) Get(claim string) interface{} {
+	return c.raw[claim]
+}
+
+// Scopes returns the space delimited scope claim, or the scp claim, as a slice
+func (c *Claims) Scopes() []string {
+	if c.Scope != "" {
+		return strings.Fields(c.Scope)
+	}
+	return c.Strings("scp")
+}
+
+// Strings returns a claim that is either an array of strings or a space delimited string
+func (c *Claims) Strings(claim string) []string {
+	switch t := c.raw[claim].(type) {
+	case string:
+		return strings.Fields(t)
+
+	case []interface{}:
+		vals := make([]string, 0, len(t))
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		return vals
+	}
+
+	return []string{}
+}
+
+// Decode decodes all of the claims into v, which can be used to access custom claims
+func (c *Claims) Decode(v interface{}) error {
+	data, err := json.Marshal(c.raw)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// RequestClaims returns the verified JWT claims from the context, or nil
+func RequestClaims(ctx context.Context) *Claims {
+	if c, ok := ctx.Value(contextKeyClaims).(*Claims); ok {
+		return c
+	}
+	return nil
+}
+
+// Key implements the KeySet interface
+func (s StaticKeySet) Key(_ context.Context, kid string, _ string) (interface{}, error) {
+	if k, ok := s[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, kid)
+}
+
+func stringsContain(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WithJWTKeySet sets the token verification key set
+func WithJWTKeySet(ks KeySet) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.keys = ks
+	}
+}
+
+// WithJWTSecret sets a shared HMAC secret used to verify tokens without a kid
+func WithJWTSecret(secret []byte) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.keys = StaticKeySet{"": secret}
+	}
+}
+
+// WithJWTAlgorithms restricts the allowed signing algorithms
+func WithJWTAlgorithms(algs ...string) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.algs = make(map[string]bool)
+		for _, alg := range algs {
+			if _, ok := jwtAlgorithms[alg]; ok {
+				a.algs[alg] = true
+			}
+		}
+	}
+}
+
+// WithJWTIssuer sets the trusted token issuers
+func WithJWTIssuer(iss ...string) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.issuers = iss
+	}
+}
+
+// WithJWTAudience sets the accepted audiences, the token must contain at least one
+func WithJWTAudience(aud ...string) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.audience = aud
+	}
+}
+
+// WithJWTClockSkew sets the allowed clock skew for the exp and nbf checks, the default is one minute
+func WithJWTClockSkew(d time.Duration) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.skew = d
+	}
+}
+
+// WithJWTRequireExpiration sets whether tokens without an exp claim are rejected, the default is true
+func WithJWTRequireExpiration(require bool) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.requireExp = require
+	}
+}
+
+// WithJWTRolesClaim sets the claim used for the principal roles, the default is roles
+func WithJWTRolesClaim(claim string) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.rolesClaim = claim
+	}
+}
+
+// WithJWTPermissionsClaim sets the claim used for the principal permissions, the default is permissions
+func WithJWTPermissionsClaim(claim string) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.permsClaim = claim
+	}
+}
+
+// WithJWTRealm sets the realm for the WWW-Authenticate challenge
+func WithJWTRealm(realm string) JWTOption {
+	return func(a *jwtAuthorizer) {
+		a.realm = realm
+	}
+}
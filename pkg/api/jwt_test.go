@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestJWT signs the claims with an HS256 secret or ES256 P-256 key
+func signTestJWT(t *testing.T, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	hdr := map[string]string{"typ": "JWT", "kid": kid}
+
+	switch key.(type) {
+	case []byte:
+		hdr["alg"] = "HS256"
+	case *ecdsa.PrivateKey:
+		hdr["alg"] = "ES256"
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(hdr) + "." + encode(claims)
+
+	var sig []byte
+
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+type errKeySet struct {
+	err error
+}
+
+func (k errKeySet) Key(context.Context, string, string) (interface{}, error) {
+	return nil, k.err
+}
+
+func TestJWTAuthorizer(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "user",
+			"iss":   "https://issuer",
+			"aud":   "api",
+			"exp":   now.Add(time.Hour).Unix(),
+			"scope": "read write",
+			"roles": []string{"admin"},
+		}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		opts        []JWTOption
+		token       string
+		wantErr     error
+		wantScopes  []string
+		wantRoles   []string
+		notInHeader string
+	}{
+		{
+			name:       "valid",
+			opts:       []JWTOption{WithJWTSecret(secret), WithJWTIssuer("https://issuer"), WithJWTAudience("api")},
+			token:      signTestJWT(t, "", secret, claims(nil)),
+			wantScopes: []string{"read", "write"},
+			wantRoles:  []string{"admin"},
+		},
+		{
+			name:    "missing",
+			opts:    []JWTOption{WithJWTSecret(secret)},
+			wantErr: ErrTokenMissing,
+		},
+		{
+			name:    "malformed",
+			opts:    []JWTOption{WithJWTSecret(secret)},
+			token:   "not.a-token",
+			wantErr: ErrTokenMalformed,
+		},
+		{
+			name:    "bad signature",
+			opts:    []JWTOption{WithJWTSecret(secret)},
+			token:   signTestJWT(t, "", []byte("other"), claims(nil)),
+			wantErr: ErrTokenSignature,
+		},
+		{
+			name:    "algorithm not allowed",
+			opts:    []JWTOption{WithJWTSecret(secret), WithJWTAlgorithms("RS256")},
+			token:   signTestJWT(t, "", secret, claims(nil)),
+			wantErr: ErrTokenAlgorithm,
+		},
+		{
+			name:    "expired",
+			opts:    []JWTOption{WithJWTSecret(secret)},
+			token:   signTestJWT(t, "", secret, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})),
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name:    "expiration required",
+			opts:    []JWTOption{WithJWTSecret(secret)},
+			token:   signTestJWT(t, "", secret, claims(map[string]interface{}{"exp": nil})),
+			wantErr: ErrTokenExpirationMissing,
+		},
+		{
+			name:    "not valid yet",
+			opts:    []JWTOption{WithJWTSecret(secret)},
+			token:   signTestJWT(t, "", secret, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})),
+			wantErr: ErrTokenNotValidYet,
+		},
+		{
+			name:    "untrusted issuer",
+			opts:    []JWTOption{WithJWTSecret(secret), WithJWTIssuer("https://other")},
+			token:   signTestJWT(t, "", secret, claims(nil)),
+			wantErr: ErrTokenIssuer,
+		},
+		{
+			name:    "wrong audience",
+			opts:    []JWTOption{WithJWTSecret(secret), WithJWTAudience("other")},
+			token:   signTestJWT(t, "", secret, claims(nil)),
+			wantErr: ErrTokenAudience,
+		},
+		{
+			name:        "key set errors are not exposed",
+			opts:        []JWTOption{WithJWTKeySet(errKeySet{errors.New("open /etc/keys/jwks.json: permission denied")})},
+			token:       signTestJWT(t, "", secret, claims(nil)),
+			wantErr:     ErrKeyNotFound,
+			notInHeader: "/etc/keys",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			ctx, err := JWTAuthorizer(tt.opts...)(r)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+
+				var authErr *AuthError
+				if !errors.As(err, &authErr) || authErr.Status() != 401 || len(authErr.Challenges()) == 0 {
+					t.Fatalf("error = %#v, want a 401 AuthError with a challenge", err)
+				}
+
+				if tt.notInHeader != "" {
+					for _, c := range authErr.Challenges() {
+						if strings.Contains(c, tt.notInHeader) {
+							t.Errorf("challenge %q exposes %q", c, tt.notInHeader)
+						}
+					}
+					if strings.Contains(err.Error(), tt.notInHeader) {
+						t.Errorf("error %q exposes %q", err, tt.notInHeader)
+					}
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := RequestPrincipal(ctx)
+			if p == nil || p.Subject != "user" {
+				t.Fatalf("principal = %+v", p)
+			}
+
+			if !reflect.DeepEqual(p.Scopes, tt.wantScopes) {
+				t.Errorf("scopes = %v, want %v", p.Scopes, tt.wantScopes)
+			}
+
+			if !reflect.DeepEqual(p.Roles, tt.wantRoles) {
+				t.Errorf("roles = %v, want %v", p.Roles, tt.wantRoles)
+			}
+
+			if c := RequestClaims(ctx); c == nil || c.Issuer != "https://issuer" {
+				t.Errorf("claims = %+v", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type (
+	// ShutdownHook is called during server shutdown, the context is the shutdown context
+	ShutdownHook func(ctx context.Context) error
+
+	lifecycle struct {
+		preShutdown     []ShutdownHook
+		postShutdown    []ShutdownHook
+		drainDelay      time.Duration
+		shutdownTimeout time.Duration
+		inflight        int64
+		conns           int64
+		closing         chan struct{}
+		done            chan struct{}
+		serveErr        chan error
+		lock            sync.Mutex
+	}
+)
+
+const (
+	defaultShutdownTimeout = time.Second * 30
+
+	shutdownPollInterval = time.Millisecond * 50
+)
+
+var (
+	// ErrServerRunning is returned when starting a server that is already running
+	ErrServerRunning = errors.New("server already running")
+
+	// ErrServerNotRunning is returned when shutting down a server that is not running
+	ErrServerNotRunning = errors.New("server not running")
+
+	contextKeyLifecycle = contextKey("lifecycle")
+)
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{
+		shutdownTimeout: defaultShutdownTimeout,
+		closing:         make(chan struct{}),
+		done:            make(chan struct{}),
+		serveErr:        make(chan error, 1),
+	}
+}
+
+// reset prepares the lifecycle for a new serve
+func (l *lifecycle) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.closing = make(chan struct{})
+	l.done = make(chan struct{})
+	l.serveErr = make(chan error, 1)
+}
+
+// notify closes the closing channel, signaling long-lived connections to terminate
+func (l *lifecycle) notify() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	select {
+	case <-l.closing:
+	default:
+		close(l.closing)
+	}
+}
+
+// finish closes the done channel, signaling that shutdown has completed
+func (l *lifecycle) finish() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+}
+
+func (l *lifecycle) doneChan() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.done
+}
+
+func (l *lifecycle) closingChan() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.closing
+}
+
+// wait blocks until all in-flight requests and tracked connections complete or the context is done
+func (l *lifecycle) wait(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if atomic.LoadInt64(&l.inflight) == 0 && atomic.LoadInt64(&l.conns) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *lifecycle) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&l.inflight, 1)
+		defer atomic.AddInt64(&l.inflight, -1)
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyLifecycle, l))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InFlight returns the number of requests currently being handled
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.lifecycle.inflight)
+}
+
+// Connections returns the number of tracked long-lived connections
+func (s *Server) Connections() int64 {
+	return atomic.LoadInt64(&s.lifecycle.conns)
+}
+
+// ListenAndServe starts the server and blocks until the context is canceled, the process
+// receives SIGINT or SIGTERM, or the listener fails; the server is then gracefully shutdown.
+// It also returns when the server is shutdown by a call to Shutdown.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := s.Serve(); err != nil {
+		return err
+	}
+
+	done := s.lifecycle.doneChan()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var serveErr error
+
+	select {
+	case <-ctx.Done():
+		s.log.Debug("context done, shutting down")
+
+	case v := <-sig:
+		s.log.Debugf("received signal %s, shutting down", v)
+
+	case serveErr = <-s.lifecycle.serveErr:
+		s.log.Errorf("listener failed: %s", serveErr)
+
+	case <-done:
+		s.log.Debug("server shutdown")
+		return nil
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.lifecycle.shutdownTimeout)
+	defer cancel()
+
+	if err := s.Shutdown(shutdownCtx); err != nil && err != ErrServerNotRunning {
+		return err
+	}
+
+	return serveErr
+}
+
+// TrackConnection registers a long-lived connection (SSE, WebSocket) with the server, the returned
+// channel is closed when the server begins shutting down and the handler should then close the
+// connection and call done; Shutdown will wait for tracked connections until its context expires
+func TrackConnection(ctx context.Context) (closing <-chan struct{}, done func()) {
+	l, ok := ctx.Value(contextKeyLifecycle).(*lifecycle)
+	if !ok {
+		return make(chan struct{}), func() {}
+	}
+
+	atomic.AddInt64(&l.conns, 1)
+
+	var once sync.Once
+
+	return l.closingChan(), func() {
+		once.Do(func() {
+			atomic.AddInt64(&l.conns, -1)
+		})
+	}
+}
+
+// WithPreShutdownHook adds a hook that is called when shutdown begins, before the drain delay
+// and before the listener is closed
+func WithPreShutdownHook(h ShutdownHook) Option {
+	return func(s *Server) {
+		s.lifecycle.preShutdown = append(s.lifecycle.preShutdown, h)
+	}
+}
+
+// WithPostShutdownHook adds a hook that is called after the http server has been shutdown
+func WithPostShutdownHook(h ShutdownHook) Option {
+	return func(s *Server) {
+		s.lifecycle.postShutdown = append(s.lifecycle.postShutdown, h)
+	}
+}
+
+// WithDrainDelay sets the delay between failing the readiness probe and closing the listener,
+// this gives load balancers time to stop routing traffic to the server
+func WithDrainDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.lifecycle.drainDelay = d
+	}
+}
+
+// WithShutdownTimeout sets the shutdown timeout used by ListenAndServe
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		if d > 0 {
+			s.lifecycle.shutdownTimeout = d
+		}
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestListener(t *testing.T) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestListenAndServeReturns(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(s *Server, cancel context.CancelFunc)
+	}{
+		{
+			name: "context canceled",
+			stop: func(s *Server, cancel context.CancelFunc) {
+				cancel()
+			},
+		},
+		{
+			name: "shutdown called",
+			stop: func(s *Server, cancel context.CancelFunc) {
+				if err := s.Shutdown(context.Background()); err != nil {
+					t.Errorf("shutdown: %s", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithListener(newTestListener(t)))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			result := make(chan error, 1)
+
+			go func() {
+				result <- s.ListenAndServe(ctx)
+			}()
+
+			// wait for the server to start
+			for i := 0; ; i++ {
+				s.lock.Lock()
+				running := s.srv != nil
+				s.lock.Unlock()
+
+				if running {
+					break
+				}
+
+				if i > 100 {
+					t.Fatal("server did not start")
+				}
+
+				time.Sleep(time.Millisecond * 10)
+			}
+
+			tt.stop(s, cancel)
+
+			select {
+			case err := <-result:
+				if err != nil {
+					t.Errorf("ListenAndServe() = %v, want nil", err)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("ListenAndServe did not return")
+			}
+		})
+	}
+}
+
+func TestShutdownHooks(t *testing.T) {
+	var (
+		calls []string
+		lock  sync.Mutex
+	)
+
+	hook := func(name string, err error) ShutdownHook {
+		return func(ctx context.Context) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			calls = append(calls, name)
+			return err
+		}
+	}
+
+	hookErr := errors.New("hook failed")
+
+	s := NewServer(
+		WithListener(newTestListener(t)),
+		WithPreShutdownHook(hook("pre", nil)),
+		WithPostShutdownHook(hook("post1", hookErr)),
+		WithPostShutdownHook(hook("post2", nil)),
+	)
+
+	if err := s.Serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Serve(); err != ErrServerRunning {
+		t.Errorf("Serve() = %v, want %v", err, ErrServerRunning)
+	}
+
+	err := s.Shutdown(context.Background())
+
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0] != hookErr {
+		t.Errorf("Shutdown() = %v, want %v", err, hookErr)
+	}
+
+	if want := []string{"pre", "post1", "post2"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks = %v, want %v", calls, want)
+	}
+
+	if err := s.Shutdown(context.Background()); err != ErrServerNotRunning {
+		t.Errorf("Shutdown() = %v, want %v", err, ErrServerNotRunning)
+	}
+}
+
+func TestTrackConnection(t *testing.T) {
+	s := NewServer(WithListener(newTestListener(t)))
+
+	tracked := make(chan struct{})
+	closed := make(chan struct{})
+
+	s.router.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		closing, done := TrackConnection(r.Context())
+		defer done()
+
+		close(tracked)
+		<-closing
+		close(closed)
+	})
+
+	if err := s.Serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	go http.Get("http://" + s.listener.Addr().String() + "/stream")
+
+	select {
+	case <-tracked:
+	case <-time.After(time.Second * 5):
+		t.Fatal("connection not tracked")
+	}
+
+	if n := s.Connections(); n != 1 {
+		t.Errorf("Connections() = %d, want 1", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+
+	select {
+	case <-closed:
+	default:
+		t.Error("connection was not notified")
+	}
+
+	if n := s.Connections(); n != 0 {
+		t.Errorf("Connections() = %d, want 0", n)
+	}
+}
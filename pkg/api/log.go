@@ -9,6 +9,9 @@
 package api
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"time"
@@ -42,12 +45,26 @@ func (rw *responseWriter) WriteHeader(code int) {
 	return
 }
 
-func getRemoteAddr(r *http.Request) string {
-	forwarded := r.Header.Get("X-FORWARDED-FOR")
-	if forwarded != "" {
-		return forwarded
+// Flush implements http.Flusher for streaming responses
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
-	return r.RemoteAddr
+}
+
+// Hijack implements http.Hijacker for upgraded connections
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	if !rw.wroteHeader {
+		rw.status = http.StatusSwitchingProtocols
+		rw.wroteHeader = true
+	}
+
+	return h.Hijack()
 }
 
 // LogMiddleware is simple logging middleware handler
@@ -70,7 +87,7 @@ func (s *Server) LogMiddleware() func(http.Handler) http.Handler {
 			s.log.WithFields(
 				log.Fields{
 					"status":    wrapped.Status(),
-					"remote":    getRemoteAddr(r),
+					"remote":    requestClientIP(r),
 					"headers":   wrapped.Header(),
 					"userAgent": r.UserAgent(),
 					"dur":       time.Since(start).String(),
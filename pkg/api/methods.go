@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type (
+	// headResponseWriter discards the body of a response to a HEAD request
+	headResponseWriter struct {
+		http.ResponseWriter
+	}
+)
+
+var (
+	// ErrNotFound is returned when no route matches the request path
+	ErrNotFound = errors.New("not found")
+
+	// ErrMethodNotAllowed is returned when a route matches the request path but not the method
+	ErrMethodNotAllowed = errors.New("method not allowed")
+)
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Flush implements http.Flusher for streaming responses
+func (w headResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// headHandler answers HEAD requests for the path with the HEAD route, or with the GET route and
+// the body discarded
+func (s *Server) headHandler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.routeLock.Lock()
+		head := s.routes[http.MethodHead+" "+path]
+		get := s.routes[http.MethodGet+" "+path]
+		s.routeLock.Unlock()
+
+		if head != nil {
+			s.routeDispatcher(head).ServeHTTP(w, r)
+			return
+		}
+
+		s.routeDispatcher(get).ServeHTTP(headResponseWriter{w}, r)
+	})
+}
+
+// allowedMethods returns the methods of the router routes that match the request path
+func (s *Server) allowedMethods(r *http.Request) []string {
+	methods := make([]string, 0)
+
+	s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		rm, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, m := range rm {
+			if stringsContain(methods, m) {
+				continue
+			}
+
+			req := r.Clone(r.Context())
+			req.Method = m
+
+			if route.Match(req, &mux.RouteMatch{}) {
+				methods = append(methods, m)
+			}
+		}
+
+		return nil
+	})
+
+	sort.Strings(methods)
+
+	return methods
+}
+
+// methodNotAllowedHandler sets the Allow header and calls the method not allowed handler
+func (s *Server) methodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if methods := s.allowedMethods(r); len(methods) > 0 {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+		}
+
+		if s.methodNotAllowed != nil {
+			s.methodNotAllowed.ServeHTTP(w, r)
+			return
+		}
+
+		s.WriteError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+	})
+}
+
+// notFoundHandler calls the not found handler
+func (s *Server) notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.notFound != nil {
+			s.notFound.ServeHTTP(w, r)
+			return
+		}
+
+		s.WriteError(w, http.StatusNotFound, ErrNotFound)
+	})
+}
+
+// WithNotFoundHandler sets the handler for requests that do not match a route
+func WithNotFoundHandler(h http.Handler) Option {
+	return func(s *Server) {
+		s.notFound = h
+	}
+}
+
+// WithMethodNotAllowedHandler sets the handler for requests that match a route path but not the
+// method, the Allow header is set before the handler is called
+func WithMethodNotAllowedHandler(h http.Handler) Option {
+	return func(s *Server) {
+		s.methodNotAllowed = h
+	}
+}
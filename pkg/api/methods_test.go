@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteMethods(t *testing.T) {
+	s := NewServer(WithBasepath("/"))
+
+	method := func(w http.ResponseWriter, r *http.Request) Responder {
+		return NewResponse(r.Method).WithHeader("X-Method", r.Method)
+	}
+
+	s.AddRoute("/items", method, WithMethods("get", "post"))
+	s.AddRoute("/docs", method)
+	s.AddRoute("/docs", func(ctx context.Context) Responder {
+		return NewResponse(nil).WithHeader("X-Method", "head route")
+	}, WithMethod(http.MethodHead))
+	s.AddRoute("/uploads", method, WithMethod(http.MethodPut))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantHeader string
+		wantAllow  string
+	}{
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			path:       "/items",
+			wantStatus: http.StatusOK,
+			wantBody:   "GET",
+			wantHeader: "GET",
+		},
+		{
+			name:       "post",
+			method:     http.MethodPost,
+			path:       "/items",
+			wantStatus: http.StatusOK,
+			wantBody:   "POST",
+			wantHeader: "POST",
+		},
+		{
+			name:       "automatic head",
+			method:     http.MethodHead,
+			path:       "/items",
+			wantStatus: http.StatusOK,
+			wantHeader: "HEAD",
+		},
+		{
+			name:       "head route",
+			method:     http.MethodHead,
+			path:       "/docs",
+			wantStatus: http.StatusOK,
+			wantHeader: "head route",
+		},
+		{
+			name:       "no head without get",
+			method:     http.MethodHead,
+			path:       "/uploads",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  "OPTIONS, PUT",
+		},
+		{
+			name:       "method not allowed",
+			method:     http.MethodDelete,
+			path:       "/items",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  "GET, HEAD, OPTIONS, POST",
+		},
+		{
+			name:       "not found",
+			method:     http.MethodGet,
+			path:       "/missing",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+
+			if got := w.Header().Get("X-Method"); got != tt.wantHeader {
+				t.Errorf("X-Method = %q, want %q", got, tt.wantHeader)
+			}
+
+			if got := w.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestCustomErrorHandlers(t *testing.T) {
+	handler := func(status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})
+	}
+
+	s := NewServer(
+		WithBasepath("/"),
+		WithNotFoundHandler(handler(http.StatusGone)),
+		WithMethodNotAllowedHandler(handler(http.StatusTeapot)),
+	)
+
+	s.AddRoute("/items", func(ctx context.Context) Responder {
+		return NewResponse("ok")
+	})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	if w.Code != http.StatusGone {
+		t.Errorf("not found status = %d, want %d", w.Code, http.StatusGone)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/items", nil))
+
+	if w.Code != http.StatusTeapot {
+		t.Errorf("method not allowed status = %d, want %d", w.Code, http.StatusTeapot)
+	}
+
+	if got := w.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("Allow = %q, want the route methods", got)
+	}
+}
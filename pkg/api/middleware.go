@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"net/http"
+)
+
+type (
+	// Middleware wraps an http handler
+	Middleware func(http.Handler) http.Handler
+)
+
+// chain wraps the handler with the middleware, the first middleware is the outermost
+func chain(h http.Handler, mw []Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// buildHandler creates the server handler, every request passes through the same stack in
+// this order:
+//
+//	lifecycle tracking
+//	trusted proxy client resolution
+//	global middleware (WithMiddleware)
+//	router, health checks are matched here
+//	api logging and version negotiation
+//	api middleware (WithAPIMiddleware)
+//	route dispatch, cors and deprecation
+//	route middleware (WithRouteMiddleware)
+//	route concurrency, timeout, authorization and the handler
+func (s *Server) buildHandler() http.Handler {
+	h := chain(s.router, s.middleware)
+
+	h = s.proxies.middleware()(h)
+
+	return s.lifecycle.middleware(h)
+}
+
+// WithMiddleware adds middleware that wraps the entire server handler, including unmatched
+// routes and health checks; middleware is called in the order added
+func WithMiddleware(mw ...Middleware) Option {
+	return func(s *Server) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
+
+// WithAPIMiddleware adds middleware to the api routes under the base path, it is called after
+// the request is logged and the version negotiated
+func WithAPIMiddleware(mw ...Middleware) Option {
+	return func(s *Server) {
+		s.apiMiddleware = append(s.apiMiddleware, mw...)
+	}
+}
+
+// WithRouteMiddleware adds middleware to the route handler, it is called after the version
+// specific handler is selected and before the route authorization
+func WithRouteMiddleware(mw ...Middleware) RouteOption {
+	return func(r *routeOption) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// callRecorder records the order middleware, authorizers and handlers are called in
+type callRecorder struct {
+	calls []string
+	lock  sync.Mutex
+}
+
+func (c *callRecorder) record(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.calls = append(c.calls, name)
+}
+
+func (c *callRecorder) middleware(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.record(name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	calls := &callRecorder{}
+
+	s := NewServer(
+		WithBasepath("/api"),
+		WithHealthPaths("/healthz", ""),
+		WithMiddleware(calls.middleware("global1"), calls.middleware("global2")),
+		WithAPIMiddleware(calls.middleware("api")),
+	)
+
+	s.AddRoute("/items", func(ctx context.Context) Responder {
+		calls.record("handler")
+		return NewResponse("ok")
+	},
+		WithRouteMiddleware(calls.middleware("route1"), calls.middleware("route2")),
+		WithAuthorizers(func(r *http.Request) (context.Context, error) {
+			calls.record("auth")
+			return nil, nil
+		}),
+	)
+
+	tests := []struct {
+		name       string
+		path       string
+		serve      http.Handler
+		wantStatus int
+		wantCalls  []string
+	}{
+		{
+			name:       "route",
+			path:       "/api/items",
+			serve:      s,
+			wantStatus: http.StatusOK,
+			wantCalls:  []string{"global1", "global2", "api", "route1", "route2", "auth", "handler"},
+		},
+		{
+			name:       "route from handler",
+			path:       "/api/items",
+			serve:      s.Handler(),
+			wantStatus: http.StatusOK,
+			wantCalls:  []string{"global1", "global2", "api", "route1", "route2", "auth", "handler"},
+		},
+		{
+			name:       "health check",
+			path:       "/healthz",
+			serve:      s,
+			wantStatus: http.StatusOK,
+			wantCalls:  []string{"global1", "global2"},
+		},
+		{
+			name:       "not found",
+			path:       "/missing",
+			serve:      s,
+			wantStatus: http.StatusNotFound,
+			wantCalls:  []string{"global1", "global2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.calls = nil
+
+			w := httptest.NewRecorder()
+			tt.serve.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if !reflect.DeepEqual(calls.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}
+
+	s := NewServer(WithBasepath("/"))
+
+	called := false
+
+	s.AddRoute("/items", func(ctx context.Context) Responder {
+		called = true
+		return NewResponse("ok")
+	}, WithRouteMiddleware(deny))
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+
+	if w.Code != http.StatusTeapot || called {
+		t.Errorf("status = %d, handler called = %v, want the middleware response", w.Code, called)
+	}
+}
@@ -0,0 +1,359 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type (
+	// ClientInfo is the client address and the scheme, host and port the client used, derived from
+	// the forwarding headers of trusted proxies
+	ClientInfo struct {
+		// IP is the client ip address
+		IP string
+
+		// Scheme is the client request scheme, http or https
+		Scheme string
+
+		// Host is the client request host without the port
+		Host string
+
+		// Port is the client request port
+		Port string
+
+		// Proxies are the trusted proxy addresses the request passed through, nearest last
+		Proxies []string
+	}
+
+	proxyOptions struct {
+		trusted []*net.IPNet
+		hops    int
+	}
+
+	forwardedElement struct {
+		forAddr string
+		proto   string
+		host    string
+	}
+)
+
+var (
+	contextKeyClient = contextKey("client")
+)
+
+// trusts returns true if the address at hop (0 being the connected peer) is a trusted proxy
+func (o *proxyOptions) trusts(addr string, hop int) bool {
+	if hop < o.hops {
+		return true
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range o.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// client derives the client info from the request, the forwarding headers are only used when
+// the connected peer is trusted and are walked right to left until an untrusted address; the
+// scheme and host are taken from the hop added by the outermost trusted proxy, the X-Forwarded-*
+// headers are ignored if the Forwarded header is present
+func (o *proxyOptions) client(r *http.Request) ClientInfo {
+	peer := stripPort(r.RemoteAddr)
+
+	info := ClientInfo{
+		IP:     peer,
+		Scheme: "http",
+	}
+
+	if r.TLS != nil {
+		info.Scheme = "https"
+	}
+
+	info.Host, info.Port = splitHostPort(r.Host)
+
+	if !o.trusts(peer, 0) {
+		return info.withDefaultPort()
+	}
+
+	var elements []forwardedElement
+
+	fwd := r.Header.Values("Forwarded")
+
+	if len(fwd) > 0 {
+		elements = parseForwarded(fwd)
+	} else {
+		for _, addr := range headerList(r.Header.Values("X-Forwarded-For")) {
+			elements = append(elements, forwardedElement{forAddr: stripPort(addr)})
+		}
+	}
+
+	addrs := make([]string, 0, len(elements)+1)
+	for _, e := range elements {
+		addrs = append(addrs, e.forAddr)
+	}
+	addrs = append(addrs, peer)
+
+	i := len(addrs) - 1
+	for i > 0 && o.trusts(addrs[i], len(addrs)-1-i) {
+		if net.ParseIP(addrs[i-1]) == nil {
+			// obfuscated or unknown identifiers end the chain
+			break
+		}
+		i--
+	}
+
+	info.IP = addrs[i]
+	info.Proxies = addrs[i+1:]
+
+	// no trusted proxy forwarded the request on behalf of the client
+	if i >= len(elements) {
+		return info.withDefaultPort()
+	}
+
+	// the element added by the edge proxy describes the original request
+	if len(fwd) > 0 {
+		if p := elements[i].proto; p != "" {
+			info.Scheme = strings.ToLower(p)
+		}
+		if h := elements[i].host; h != "" {
+			info.Host, info.Port = splitHostPort(h)
+		}
+
+		return info.withDefaultPort()
+	}
+
+	if p := hopValue(r.Header.Values("X-Forwarded-Proto"), len(elements), i); p != "" {
+		info.Scheme = strings.ToLower(p)
+	}
+
+	if h := hopValue(r.Header.Values("X-Forwarded-Host"), len(elements), i); h != "" {
+		info.Host, info.Port = splitHostPort(h)
+	}
+
+	if p := hopValue(r.Header.Values("X-Forwarded-Port"), len(elements), i); p != "" {
+		info.Port = p
+	}
+
+	return info.withDefaultPort()
+}
+
+func (c ClientInfo) withDefaultPort() ClientInfo {
+	if c.Port == "" {
+		if c.Scheme == "https" {
+			c.Port = "443"
+		} else {
+			c.Port = "80"
+		}
+	}
+	return c
+}
+
+// middleware adds the client info to the request context
+func (o *proxyOptions) middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := o.client(r)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyClient, c)))
+		})
+	}
+}
+
+// parseForwarded parses RFC 7239 Forwarded header values into elements
+func parseForwarded(values []string) []forwardedElement {
+	elements := make([]forwardedElement, 0)
+
+	for _, v := range values {
+		for _, el := range splitQuoted(v, ',') {
+			e := forwardedElement{}
+
+			for _, pair := range splitQuoted(el, ';') {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+
+				val := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+
+				switch strings.ToLower(strings.TrimSpace(kv[0])) {
+				case "for":
+					e.forAddr = stripPort(val)
+				case "proto":
+					e.proto = val
+				case "host":
+					e.host = val
+				}
+			}
+
+			elements = append(elements, e)
+		}
+	}
+
+	return elements
+}
+
+// splitQuoted splits s on sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	parts := make([]string, 0)
+	quoted := false
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// headerList returns the comma separated values of the header lines
+func headerList(values []string) []string {
+	list := make([]string, 0)
+
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+// hopValue returns the X-Forwarded-* value added by the proxy at hop i, the values must align
+// with the X-Forwarded-For addresses, otherwise a client supplied value could be used
+func hopValue(values []string, hops, i int) string {
+	list := headerList(values)
+	if len(list) != hops {
+		return ""
+	}
+	return list[i]
+}
+
+// stripPort removes the port and ipv6 brackets from an address
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+func splitHostPort(hostport string) (string, string) {
+	u := url.URL{Host: hostport}
+	return u.Hostname(), u.Port()
+}
+
+// RequestClient returns the client info for the request, without trusted proxies the connected
+// peer and request host are used
+func RequestClient(ctx context.Context) ClientInfo {
+	if c, ok := ctx.Value(contextKeyClient).(ClientInfo); ok {
+		return c
+	}
+
+	r, _ := Request(ctx)
+	if r == nil {
+		return ClientInfo{}
+	}
+
+	return (&proxyOptions{}).client(r)
+}
+
+// RequestClientIP returns the client ip address for the request
+func RequestClientIP(ctx context.Context) string {
+	return RequestClient(ctx).IP
+}
+
+// RequestURL returns the absolute url the client requested
+func RequestURL(ctx context.Context) *url.URL {
+	r, _ := Request(ctx)
+	if r == nil {
+		return nil
+	}
+
+	return requestURL(r)
+}
+
+func requestURL(r *http.Request) *url.URL {
+	c, ok := r.Context().Value(contextKeyClient).(ClientInfo)
+	if !ok {
+		c = (&proxyOptions{}).client(r)
+	}
+
+	u := *r.URL
+	u.Scheme = c.Scheme
+	u.Host = c.Host
+
+	if (c.Scheme == "https" && c.Port != "443") || (c.Scheme == "http" && c.Port != "80") {
+		u.Host = net.JoinHostPort(c.Host, c.Port)
+	}
+
+	return &u
+}
+
+// requestClientIP returns the client ip from the request context or the connected peer
+func requestClientIP(r *http.Request) string {
+	if c, ok := r.Context().Value(contextKeyClient).(ClientInfo); ok {
+		return c.IP
+	}
+	return stripPort(r.RemoteAddr)
+}
+
+// WithTrustedProxies sets the addresses or CIDR ranges of proxies trusted to set the Forwarded
+// and X-Forwarded-* headers; invalid entries are ignored. Trusted proxies must set or append
+// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Port, values passed through from the client
+// cannot be told apart from those the proxy sets.
+func WithTrustedProxies(cidrs ...string) Option {
+	return func(s *Server) {
+		for _, c := range cidrs {
+			if !strings.Contains(c, "/") {
+				if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+					c += "/32"
+				} else {
+					c += "/128"
+				}
+			}
+
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				s.log.Warnf("invalid trusted proxy %s: %s", c, err)
+				continue
+			}
+
+			s.proxies.trusted = append(s.proxies.trusted, n)
+		}
+	}
+}
+
+// WithTrustedProxyHops trusts the nearest n proxies regardless of address, this is for load
+// balancers without fixed addresses
+func WithTrustedProxyHops(n int) Option {
+	return func(s *Server) {
+		s.proxies.hops = n
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProxyClient(t *testing.T) {
+	s := NewServer(WithTrustedProxies("10.0.0.0/8", "192.168.1.1"))
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string][]string
+		want    ClientInfo
+	}{
+		{
+			name: "direct client",
+			peer: "203.0.113.5:41000",
+			want: ClientInfo{IP: "203.0.113.5", Scheme: "http", Host: "api.example.com", Port: "80"},
+		},
+		{
+			name: "untrusted peer headers are ignored",
+			peer: "203.0.113.5:41000",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"evil.example.com"},
+			},
+			want: ClientInfo{IP: "203.0.113.5", Scheme: "http", Host: "api.example.com", Port: "80"},
+		},
+		{
+			name: "trusted proxy",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"www.example.com"},
+			},
+			want: ClientInfo{IP: "198.51.100.1", Scheme: "https", Host: "www.example.com", Port: "443", Proxies: []string{"10.0.0.2"}},
+		},
+		{
+			name: "proxy chain",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For":   {"198.51.100.1, 192.168.1.1"},
+				"X-Forwarded-Proto": {"https", "http"},
+				"X-Forwarded-Port":  {"8443, 80"},
+			},
+			want: ClientInfo{IP: "198.51.100.1", Scheme: "https", Host: "api.example.com", Port: "8443", Proxies: []string{"192.168.1.1", "10.0.0.2"}},
+		},
+		{
+			name: "spoofed address and host are skipped",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For":  {"127.0.0.1, 198.51.100.1"},
+				"X-Forwarded-Host": {"evil.example.com, www.example.com"},
+			},
+			want: ClientInfo{IP: "198.51.100.1", Scheme: "http", Host: "www.example.com", Port: "80", Proxies: []string{"10.0.0.2"}},
+		},
+		{
+			name: "client host passed through with an appended host",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For":  {"198.51.100.1"},
+				"X-Forwarded-Host": {"evil.example.com", "www.example.com"},
+			},
+			want: ClientInfo{IP: "198.51.100.1", Scheme: "http", Host: "api.example.com", Port: "80", Proxies: []string{"10.0.0.2"}},
+		},
+		{
+			name: "forwarding headers without a forwarded address",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"X-Forwarded-Host": {"evil.example.com"},
+			},
+			want: ClientInfo{IP: "10.0.0.2", Scheme: "http", Host: "api.example.com", Port: "80", Proxies: []string{}},
+		},
+		{
+			name: "forwarded",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"Forwarded": {`for=198.51.100.1;proto=https;host="www.example.com:8443"`},
+			},
+			want: ClientInfo{IP: "198.51.100.1", Scheme: "https", Host: "www.example.com", Port: "8443", Proxies: []string{"10.0.0.2"}},
+		},
+		{
+			name: "forwarded takes precedence over x-forwarded",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"Forwarded":         {`for="[2001:db8::1]:4711";proto=https`},
+				"X-Forwarded-For":   {"198.51.100.1"},
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"evil.example.com"},
+			},
+			want: ClientInfo{IP: "2001:db8::1", Scheme: "https", Host: "api.example.com", Port: "443", Proxies: []string{"10.0.0.2"}},
+		},
+		{
+			name: "obfuscated identifier ends the chain",
+			peer: "10.0.0.2:5000",
+			headers: map[string][]string{
+				"Forwarded": {"for=198.51.100.1, for=_hidden"},
+			},
+			want: ClientInfo{IP: "10.0.0.2", Scheme: "http", Host: "api.example.com", Port: "80", Proxies: []string{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://api.example.com/items", nil)
+			r.RemoteAddr = tt.peer
+
+			for key, vals := range tt.headers {
+				for _, v := range vals {
+					r.Header.Add(key, v)
+				}
+			}
+
+			if got := s.proxies.client(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("client() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	s := NewServer(WithTrustedProxies("10.0.0.0/8"))
+
+	r := httptest.NewRequest("POST", "http://internal:8080/v1/items", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "api.example.com")
+
+	r = r.WithContext(context.WithValue(r.Context(), contextKeyClient, s.proxies.client(r)))
+
+	w := httptest.NewRecorder()
+
+	resp := NewResponse().WithStatus(201).WithHeader("Location", "items/1")
+	if err := resp.Write(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if loc := w.Header().Get("Location"); loc != "https://api.example.com/v1/items/1" {
+		t.Errorf("Location = %q", loc)
+	}
+}
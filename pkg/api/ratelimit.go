@@ -0,0 +1,486 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// RateLimiter limits the rate of requests for a key
+	RateLimiter interface {
+		// Allow consumes a request for the key and returns the limit state
+		Allow(ctx context.Context, key string) (RateLimitResult, error)
+	}
+
+	// RateLimitResult is the result of a rate limit check
+	RateLimitResult struct {
+		// Allowed is true if the request is within the limit
+		Allowed bool
+
+		// Limit is the request quota
+		Limit int
+
+		// Remaining is the remaining quota
+		Remaining int
+
+		// Reset is the time until the quota is fully restored
+		Reset time.Duration
+
+		// RetryAfter is the time until the next request will be allowed when the limit is exceeded
+		RetryAfter time.Duration
+	}
+
+	// RateLimitStore stores rate limiter state; the operations map directly to redis (GET, INCR
+	// with PEXPIRE, and a compare and swap script) so limits can be shared between instances
+	RateLimitStore interface {
+		// Get returns the value of the key, or 0 if it does not exist
+		Get(ctx context.Context, key string) (int64, error)
+
+		// Increment atomically increments the key, setting the ttl when the key is created
+		Increment(ctx context.Context, key string, ttl time.Duration) (int64, error)
+
+		// CompareAndSwap sets the key to new with the ttl if the current value is old, a missing
+		// key has the value 0
+		CompareAndSwap(ctx context.Context, key string, old, new int64, ttl time.Duration) (bool, error)
+	}
+
+	// RateLimitKeyFunc returns the rate limit key for the request
+	RateLimitKeyFunc func(r *http.Request) string
+
+	// RateLimitOption defines rate limiter options
+	RateLimitOption func(*rateLimiterOptions)
+
+	// MemoryRateLimitStore is an in-memory RateLimitStore
+	MemoryRateLimitStore struct {
+		values map[string]*rateLimitValue
+		pruned time.Time
+		lock   sync.Mutex
+	}
+
+	rateLimitValue struct {
+		value   int64
+		expires time.Time
+	}
+
+	rateLimiterOptions struct {
+		store  RateLimitStore
+		burst  int
+		prefix string
+	}
+
+	// tokenBucket implements a token bucket using the generic cell rate algorithm, the stored
+	// value is the theoretical arrival time of the next request
+	tokenBucket struct {
+		rateLimiterOptions
+		limit    int
+		interval time.Duration
+	}
+
+	// slidingWindow approximates a sliding window by weighting the previous fixed window count,
+	// every request is counted so clients that keep retrying while limited remain limited
+	slidingWindow struct {
+		rateLimiterOptions
+		limit  int
+		window time.Duration
+	}
+
+	rateLimit struct {
+		limiter RateLimiter
+		key     RateLimitKeyFunc
+		scope   string
+	}
+)
+
+const (
+	rateLimitCASRetries = 10
+
+	memoryRateLimitPruneInterval = time.Minute
+)
+
+var (
+	// ErrRateLimited is returned when the request exceeds the rate limit
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrRateLimitContention is returned when the limiter state could not be updated
+	ErrRateLimitContention = errors.New("rate limit contention")
+)
+
+func newRateLimiterOptions(prefix string, opts ...RateLimitOption) rateLimiterOptions {
+	o := rateLimiterOptions{
+		prefix: prefix,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.store == nil {
+		o.store = NewMemoryRateLimitStore()
+	}
+
+	return o
+}
+
+// NewTokenBucket returns a token bucket RateLimiter that refills limit tokens per period, the
+// bucket size is the limit unless the burst is set; a limit less than 1 denies all requests
+func NewTokenBucket(limit int, period time.Duration, opts ...RateLimitOption) RateLimiter {
+	t := &tokenBucket{
+		rateLimiterOptions: newRateLimiterOptions("tb:"+strconv.Itoa(limit)+":"+period.String()+":", opts...),
+		limit:              limit,
+		interval:           period,
+	}
+
+	if limit > 0 {
+		t.interval = period / time.Duration(limit)
+	}
+
+	return t
+}
+
+func (t *tokenBucket) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	if t.limit <= 0 {
+		return RateLimitResult{
+			RetryAfter: t.interval,
+		}, nil
+	}
+
+	burst := t.limit
+	if t.burst > 0 {
+		burst = t.burst
+	}
+
+	key = t.prefix + key
+	offset := t.interval * time.Duration(burst)
+
+	for i := 0; i < rateLimitCASRetries; i++ {
+		now := time.Now().UnixNano()
+
+		old, err := t.store.Get(ctx, key)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+
+		tat := old
+		if tat < now {
+			tat = now
+		}
+
+		next := tat + int64(t.interval)
+		allowAt := next - int64(offset)
+
+		if now < allowAt {
+			return RateLimitResult{
+				Limit:      burst,
+				Reset:      time.Duration(tat - now),
+				RetryAfter: time.Duration(allowAt - now),
+			}, nil
+		}
+
+		ok, err := t.store.CompareAndSwap(ctx, key, old, next, time.Duration(next-now))
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		return RateLimitResult{
+			Allowed:   true,
+			Limit:     burst,
+			Remaining: int((int64(offset) - (next - now)) / int64(t.interval)),
+			Reset:     time.Duration(next - now),
+		}, nil
+	}
+
+	return RateLimitResult{}, ErrRateLimitContention
+}
+
+// NewSlidingWindow returns a sliding window RateLimiter that allows limit requests in any window,
+// a limit less than 1 denies all requests
+func NewSlidingWindow(limit int, window time.Duration, opts ...RateLimitOption) RateLimiter {
+	return &slidingWindow{
+		rateLimiterOptions: newRateLimiterOptions("sw:"+strconv.Itoa(limit)+":"+window.String()+":", opts...),
+		limit:              limit,
+		window:             window,
+	}
+}
+
+func (s *slidingWindow) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	now := time.Now().UnixNano()
+	idx := now / int64(s.window)
+	elapsed := time.Duration(now % int64(s.window))
+
+	key = s.prefix + key + ":"
+
+	prev, err := s.store.Get(ctx, key+strconv.FormatInt(idx-1, 10))
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	// the request is counted before it is checked so concurrent requests cannot exceed the limit
+	cur, err := s.store.Increment(ctx, key+strconv.FormatInt(idx, 10), s.window*2)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	weight := 1 - float64(elapsed)/float64(s.window)
+	count := float64(prev)*weight + float64(cur)
+
+	res := RateLimitResult{
+		Limit: s.limit,
+		Reset: s.window - elapsed,
+	}
+
+	if count > float64(s.limit) {
+		if cur > int64(s.limit) || prev == 0 {
+			res.RetryAfter = s.window - elapsed
+		} else {
+			// the time until the previous window weight drops enough for one request
+			need := 1 - float64(int64(s.limit)-cur)/float64(prev)
+			res.RetryAfter = time.Duration(need*float64(s.window)) - elapsed
+		}
+
+		if res.RetryAfter < time.Second {
+			res.RetryAfter = time.Second
+		}
+
+		return res, nil
+	}
+
+	res.Allowed = true
+	res.Remaining = s.limit - int(math.Ceil(count))
+
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+
+	return res, nil
+}
+
+// NewMemoryRateLimitStore returns a new in-memory RateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		values: make(map[string]*rateLimitValue),
+		pruned: time.Now(),
+	}
+}
+
+// Get implements the RateLimitStore interface
+func (m *MemoryRateLimitStore) Get(_ context.Context, key string) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.get(key), nil
+}
+
+// Increment implements the RateLimitStore interface
+func (m *MemoryRateLimitStore) Increment(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.prune()
+
+	v := m.get(key)
+	if v == 0 {
+		m.values[key] = &rateLimitValue{
+			expires: time.Now().Add(ttl),
+		}
+	}
+
+	m.values[key].value = v + 1
+
+	return v + 1, nil
+}
+
+// CompareAndSwap implements the RateLimitStore interface
+func (m *MemoryRateLimitStore) CompareAndSwap(_ context.Context, key string, old, new int64, ttl time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.prune()
+
+	if m.get(key) != old {
+		return false, nil
+	}
+
+	m.values[key] = &rateLimitValue{
+		value:   new,
+		expires: time.Now().Add(ttl),
+	}
+
+	return true, nil
+}
+
+// get returns the unexpired value, the lock must be held
+func (m *MemoryRateLimitStore) get(key string) int64 {
+	v, ok := m.values[key]
+	if !ok {
+		return 0
+	}
+
+	if time.Now().After(v.expires) {
+		delete(m.values, key)
+		return 0
+	}
+
+	return v.value
+}
+
+// prune removes expired values, the lock must be held
+func (m *MemoryRateLimitStore) prune() {
+	now := time.Now()
+
+	if now.Sub(m.pruned) < memoryRateLimitPruneInterval {
+		return
+	}
+
+	m.pruned = now
+
+	for key, v := range m.values {
+		if now.After(v.expires) {
+			delete(m.values, key)
+		}
+	}
+}
+
+// RateLimitByRemoteAddr keys requests by the client ip address, see WithTrustedProxies
+func RateLimitByRemoteAddr(r *http.Request) string {
+	return "ip:" + requestClientIP(r)
+}
+
+// RateLimitByAPIKey keys requests by the verified api key prefix, falling back to the remote
+// address so unverified keys can not consume the quota of another client
+func RateLimitByAPIKey(r *http.Request) string {
+	if p := RequestPrincipal(r.Context()); p != nil && p.Type == "apikey" {
+		if prefix, ok := p.Attributes["key_prefix"].(string); ok {
+			return "apikey:" + prefix
+		}
+	}
+
+	return RateLimitByRemoteAddr(r)
+}
+
+// RateLimitByPrincipal keys requests by the authenticated principal, falling back to the remote
+// address for anonymous requests
+func RateLimitByPrincipal(r *http.Request) string {
+	if p := RequestPrincipal(r.Context()); p != nil {
+		return "principal:" + p.Type + ":" + p.Subject
+	}
+
+	return RateLimitByRemoteAddr(r)
+}
+
+// rateLimit checks the limits in order, the headers for the most restrictive limit are written
+// and ErrRateLimited is returned with the retry delay if any limit is exceeded
+func (s *Server) rateLimit(w http.ResponseWriter, r *http.Request, limits []rateLimit) error {
+	var current *RateLimitResult
+
+	for _, l := range limits {
+		key := l.key(r)
+		if key == "" {
+			key = RateLimitByRemoteAddr(r)
+		}
+
+		res, err := l.limiter.Allow(r.Context(), l.scope+"|"+key)
+		if err != nil {
+			// fail open, the store being unavailable should not take down the api
+			s.log.Errorf("rate limit: %s", err)
+			continue
+		}
+
+		if current == nil || !res.Allowed || res.Remaining < current.Remaining {
+			current = &res
+		}
+
+		if !res.Allowed {
+			break
+		}
+	}
+
+	if current == nil {
+		return nil
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(current.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(current.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(durationSeconds(current.Reset)))
+
+	if !current.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(durationSeconds(current.RetryAfter)))
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
+// durationSeconds returns the duration rounded up to whole seconds
+func durationSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// WithRateLimitStore sets the limiter store, the default is an in-memory store owned by the limiter
+func WithRateLimitStore(store RateLimitStore) RateLimitOption {
+	return func(o *rateLimiterOptions) {
+		o.store = store
+	}
+}
+
+// WithRateLimitBurst sets the token bucket size
+func WithRateLimitBurst(n int) RateLimitOption {
+	return func(o *rateLimiterOptions) {
+		o.burst = n
+	}
+}
+
+// WithRateLimitName sets the limiter name used to prefix store keys, limiters with the same
+// configuration and store share state by default
+func WithRateLimitName(name string) RateLimitOption {
+	return func(o *rateLimiterOptions) {
+		o.prefix = name + ":"
+	}
+}
+
+// WithGlobalRateLimit adds a rate limit applied to every route, the quota is shared across routes;
+// a nil key function keys requests by remote address
+func WithGlobalRateLimit(limiter RateLimiter, key RateLimitKeyFunc) Option {
+	return func(s *Server) {
+		if key == nil {
+			key = RateLimitByRemoteAddr
+		}
+
+		s.rateLimits = append(s.rateLimits, rateLimit{
+			limiter: limiter,
+			key:     key,
+			scope:   "*",
+		})
+	}
+}
+
+// WithRateLimit adds a rate limit to the route, the quota is scoped to the route; a nil key
+// function keys requests by remote address
+func WithRateLimit(limiter RateLimiter, key RateLimitKeyFunc) RouteOption {
+	return func(r *routeOption) {
+		if key == nil {
+			key = RateLimitByRemoteAddr
+		}
+
+		r.rateLimits = append(r.rateLimits, rateLimit{
+			limiter: limiter,
+			key:     key,
+		})
+	}
+}
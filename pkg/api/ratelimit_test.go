@@ -0,0 +1,338 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+// fakeRateLimitStore is an in-memory store that records the operations and can fail them
+type fakeRateLimitStore struct {
+	values  map[string]int64
+	ops     []string
+	err     error
+	noSwaps bool
+	lock    sync.Mutex
+}
+
+func newFakeRateLimitStore() *fakeRateLimitStore {
+	return &fakeRateLimitStore{values: make(map[string]int64)}
+}
+
+func (f *fakeRateLimitStore) Get(_ context.Context, key string) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.ops = append(f.ops, "get")
+	return f.values[key], f.err
+}
+
+func (f *fakeRateLimitStore) Increment(_ context.Context, key string, _ time.Duration) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.ops = append(f.ops, "incr")
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	f.values[key]++
+	return f.values[key], nil
+}
+
+func (f *fakeRateLimitStore) CompareAndSwap(_ context.Context, key string, old, new int64, _ time.Duration) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.ops = append(f.ops, "cas")
+	if f.err != nil || f.noSwaps || f.values[key] != old {
+		return false, f.err
+	}
+
+	f.values[key] = new
+	return true, nil
+}
+
+func TestRateLimiters(t *testing.T) {
+	storeErr := errors.New("connection refused")
+
+	tests := []struct {
+		name        string
+		limiter     func(store RateLimitStore) RateLimiter
+		store       func(f *fakeRateLimitStore)
+		requests    int
+		wantAllowed []bool
+		wantOps     string
+		wantErr     error
+	}{
+		{
+			name: "sliding window",
+			limiter: func(store RateLimitStore) RateLimiter {
+				return NewSlidingWindow(2, time.Hour, WithRateLimitStore(store))
+			},
+			requests:    3,
+			wantAllowed: []bool{true, true, false},
+			wantOps:     "get incr get incr get incr",
+		},
+		{
+			name: "sliding window without quota",
+			limiter: func(store RateLimitStore) RateLimiter {
+				return NewSlidingWindow(0, time.Hour, WithRateLimitStore(store))
+			},
+			requests:    1,
+			wantAllowed: []bool{false},
+			wantOps:     "get incr",
+		},
+		{
+			name: "sliding window store error",
+			limiter: func(store RateLimitStore) RateLimiter {
+				return NewSlidingWindow(2, time.Hour, WithRateLimitStore(store))
+			},
+			store: func(f *fakeRateLimitStore) {
+				f.err = storeErr
+			},
+			requests: 1,
+			wantOps:  "get",
+			wantErr:  storeErr,
+		},
+		{
+			name:        "token bucket",
+			limiter:     func(store RateLimitStore) RateLimiter { return NewTokenBucket(2, time.Hour, WithRateLimitStore(store)) },
+			requests:    3,
+			wantAllowed: []bool{true, true, false},
+			wantOps:     "get cas get cas get",
+		},
+		{
+			name: "token bucket burst",
+			limiter: func(store RateLimitStore) RateLimiter {
+				return NewTokenBucket(1, time.Hour, WithRateLimitStore(store), WithRateLimitBurst(3))
+			},
+			requests:    4,
+			wantAllowed: []bool{true, true, true, false},
+			wantOps:     "get cas get cas get cas get",
+		},
+		{
+			name:        "token bucket without quota",
+			limiter:     func(store RateLimitStore) RateLimiter { return NewTokenBucket(0, time.Hour, WithRateLimitStore(store)) },
+			requests:    2,
+			wantAllowed: []bool{false, false},
+			wantOps:     "",
+		},
+		{
+			name:    "token bucket contention",
+			limiter: func(store RateLimitStore) RateLimiter { return NewTokenBucket(2, time.Hour, WithRateLimitStore(store)) },
+			store: func(f *fakeRateLimitStore) {
+				f.noSwaps = true
+			},
+			requests: 1,
+			wantOps:  strings.Repeat("get cas ", rateLimitCASRetries-1) + "get cas",
+			wantErr:  ErrRateLimitContention,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeRateLimitStore()
+			if tt.store != nil {
+				tt.store(store)
+			}
+
+			l := tt.limiter(store)
+
+			for i := 0; i < tt.requests; i++ {
+				res, err := l.Allow(context.Background(), "client")
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("request %d error = %v, want %v", i, err, tt.wantErr)
+				}
+				if err != nil {
+					continue
+				}
+
+				if res.Allowed != tt.wantAllowed[i] {
+					t.Errorf("request %d allowed = %v, want %v", i, res.Allowed, tt.wantAllowed[i])
+				}
+				if !res.Allowed && res.RetryAfter <= 0 {
+					t.Errorf("request %d retry after = %s, want a delay", i, res.RetryAfter)
+				}
+			}
+
+			if ops := strings.Join(store.ops, " "); ops != tt.wantOps {
+				t.Errorf("store operations = %q, want %q", ops, tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowConcurrent(t *testing.T) {
+	l := NewSlidingWindow(10, time.Hour, WithRateLimitStore(NewMemoryRateLimitStore()))
+
+	var (
+		allowed int32
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := l.Allow(context.Background(), "client")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Allowed {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// the window may have rolled over, the weighted previous count still bounds the total
+	if allowed > 10 {
+		t.Errorf("allowed %d requests, want at most 10", allowed)
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	failing := newFakeRateLimitStore()
+	failing.err = errors.New("connection refused")
+
+	tests := []struct {
+		name          string
+		limits        []rateLimit
+		wantErr       error
+		wantRemaining string
+		wantRetry     bool
+	}{
+		{
+			name: "most restrictive limit",
+			limits: []rateLimit{
+				{limiter: NewSlidingWindow(10, time.Hour, WithRateLimitStore(newFakeRateLimitStore())), key: RateLimitByRemoteAddr},
+				{limiter: NewSlidingWindow(5, time.Hour, WithRateLimitStore(newFakeRateLimitStore())), key: RateLimitByRemoteAddr},
+			},
+			wantRemaining: "4",
+		},
+		{
+			name: "exceeded",
+			limits: []rateLimit{
+				{limiter: NewTokenBucket(0, time.Hour, WithRateLimitStore(newFakeRateLimitStore())), key: RateLimitByRemoteAddr},
+			},
+			wantErr:       ErrRateLimited,
+			wantRemaining: "0",
+			wantRetry:     true,
+		},
+		{
+			name: "store errors fail open",
+			limits: []rateLimit{
+				{limiter: NewSlidingWindow(1, time.Hour, WithRateLimitStore(failing)), key: RateLimitByRemoteAddr},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithLog(&log.Logger{Handler: discard.Default}))
+
+			w := httptest.NewRecorder()
+
+			err := s.rateLimit(w, httptest.NewRequest("GET", "/", nil), tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			if got := w.Header().Get("RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("RateLimit-Remaining = %q, want %q", got, tt.wantRemaining)
+			}
+
+			if retry := w.Header().Get("Retry-After") != ""; retry != tt.wantRetry {
+				t.Errorf("Retry-After set = %v, want %v", retry, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	apiKey := &Principal{Subject: "client", Type: "apikey", Attributes: map[string]interface{}{"key_prefix": "live_ab12"}}
+	user := &Principal{Subject: "user-1", Type: "jwt"}
+
+	tests := []struct {
+		name      string
+		key       RateLimitKeyFunc
+		principal *Principal
+		header    string
+		want      string
+	}{
+		{"api key verified", RateLimitByAPIKey, apiKey, "live_ab12.secret", "apikey:live_ab12"},
+		{"api key unverified", RateLimitByAPIKey, nil, "live_ab12.guess", "ip:192.0.2.1"},
+		{"api key other principal", RateLimitByAPIKey, user, "live_ab12.guess", "ip:192.0.2.1"},
+		{"principal", RateLimitByPrincipal, user, "", "principal:jwt:user-1"},
+		{"anonymous", RateLimitByPrincipal, nil, "", "ip:192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+
+			if tt.header != "" {
+				r.Header.Set("X-API-Key", tt.header)
+			}
+
+			if tt.principal != nil {
+				r = r.WithContext(ContextWithPrincipal(r.Context(), tt.principal))
+			}
+
+			if got := tt.key(r); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitServersIndependent(t *testing.T) {
+	servers := make([]*Server, 2)
+
+	for i := range servers {
+		s := NewServer(WithBasepath("/"), WithLog(&log.Logger{Handler: discard.Default}))
+
+		s.AddRoute("/items", func(ctx context.Context) Responder {
+			return NewResponse("ok")
+		}, WithRateLimit(NewTokenBucket(1, time.Hour), nil))
+
+		servers[i] = s
+	}
+
+	for i, s := range servers {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+
+		if w.Code != 200 {
+			t.Errorf("server %d status = %d, want 200", i, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	servers[0].ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+
+	if w.Code != 429 {
+		t.Errorf("second request status = %d, want 429", w.Code)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Requirement is an authorization requirement evaluated against the request principal
+	Requirement interface {
+		// Allow returns true if the principal satisfies the requirement
+		Allow(p *Principal) bool
+
+		// String returns the requirement expression
+		String() string
+	}
+
+	requirementKind string
+
+	requirement struct {
+		kind  requirementKind
+		value string
+	}
+
+	requirementSet struct {
+		any  bool
+		reqs []Requirement
+	}
+)
+
+const (
+	requireScope      requirementKind = "scope"
+	requireRole       requirementKind = "role"
+	requirePermission requirementKind = "permission"
+)
+
+// Scope requires the principal to have the oauth scope
+func Scope(scope string) Requirement {
+	return &requirement{requireScope, scope}
+}
+
+// Role requires the principal to have the role
+func Role(role string) Requirement {
+	return &requirement{requireRole, role}
+}
+
+// Permission requires the principal to have the permission
+func Permission(perm string) Requirement {
+	return &requirement{requirePermission, perm}
+}
+
+// RequireAll requires all of the requirements to be satisfied
+func RequireAll(reqs ...Requirement) Requirement {
+	return &requirementSet{reqs: reqs}
+}
+
+// RequireAny requires at least one of the requirements to be satisfied
+func RequireAny(reqs ...Requirement) Requirement {
+	return &requirementSet{any: true, reqs: reqs}
+}
+
+func (r *requirement) Allow(p *Principal) bool {
+	switch r.kind {
+	case requireScope:
+		return p.HasScope(r.value)
+	case requireRole:
+		return p.HasRole(r.value)
+	case requirePermission:
+		return p.HasPermission(r.value)
+	}
+	return false
+}
+
+func (r *requirement) String() string {
+	return fmt.Sprintf("%s(%s)", r.kind, r.value)
+}
+
+func (r *requirementSet) Allow(p *Principal) bool {
+	for _, req := range r.reqs {
+		ok := req.Allow(p)
+
+		if r.any && ok {
+			return true
+		} else if !r.any && !ok {
+			return false
+		}
+	}
+
+	return !r.any || len(r.reqs) == 0
+}
+
+func (r *requirementSet) String() string {
+	op := " AND "
+	if r.any {
+		op = " OR "
+	}
+
+	parts := make([]string, 0, len(r.reqs))
+	for _, req := range r.reqs {
+		parts = append(parts, req.String())
+	}
+
+	return "(" + strings.Join(parts, op) + ")"
+}
+
+// requirementValues returns the unique values of the kind referenced by the requirement
+func requirementValues(req Requirement, kind requirementKind) []string {
+	vals := make([]string, 0)
+
+	var walk func(Requirement)
+
+	walk = func(req Requirement) {
+		switch t := req.(type) {
+		case *requirement:
+			if t.kind == kind && !stringsContain(vals, t.value) {
+				vals = append(vals, t.value)
+			}
+		case *requirementSet:
+			for _, r := range t.reqs {
+				walk(r)
+			}
+		}
+	}
+
+	walk(req)
+
+	return vals
+}
+
+// authorize checks the request principal against the requirement, returning a 401 if
+// the request is not authenticated and a 403 if the requirement is not satisfied
+func (r *requirementSet) authorize(req *http.Request) error {
+	p := RequestPrincipal(req.Context())
+	if p == nil {
+		return NewAuthError(http.StatusUnauthorized, "", ErrUnauthenticated)
+	}
+
+	if !r.Allow(p) {
+		return NewAuthError(http.StatusForbidden, "", fmt.Errorf("%w: requires %s", ErrForbidden, r))
+	}
+
+	return nil
+}
+
+// WithRequirements sets the authorization requirements for the route, all requirements must be
+// satisfied by the principal set by the route authorizers; the required scopes, roles and
+// permissions are recorded in the route metadata
+func WithRequirements(reqs ...Requirement) RouteOption {
+	return func(o *routeOption) {
+		if o.requires == nil {
+			o.requires = &requirementSet{}
+		}
+
+		o.requires.reqs = append(o.requires.reqs, reqs...)
+
+		o.metadata["requires"] = o.requires.String()
+
+		for key, kind := range map[string]requirementKind{
+			"scopes":      requireScope,
+			"roles":       requireRole,
+			"permissions": requirePermission,
+		} {
+			if vals := requirementValues(o.requires, kind); len(vals) > 0 {
+				o.metadata[key] = vals
+			}
+		}
+	}
+}
+
+// WithScopes requires the principal to have all of the scopes
+func WithScopes(scopes ...string) RouteOption {
+	reqs := make([]Requirement, 0, len(scopes))
+	for _, s := range scopes {
+		reqs = append(reqs, Scope(s))
+	}
+	return WithRequirements(reqs...)
+}
+
+// WithRoles requires the principal to have all of the roles
+func WithRoles(roles ...string) RouteOption {
+	reqs := make([]Requirement, 0, len(roles))
+	for _, r := range roles {
+		reqs = append(reqs, Role(r))
+	}
+	return WithRequirements(reqs...)
+}
+
+// WithPermissions requires the principal to have all of the permissions
+func WithPermissions(perms ...string) RouteOption {
+	reqs := make([]Requirement, 0, len(perms))
+	for _, p := range perms {
+		reqs = append(reqs, Permission(p))
+	}
+	return WithRequirements(reqs...)
+}
+
+// WithMetadata adds documentation metadata to the route
+func WithMetadata(key string, value interface{}) RouteOption {
+	return func(o *routeOption) {
+		o.metadata[key] = value
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRequirementAllow(t *testing.T) {
+	p := &Principal{
+		Scopes:      []string{"read"},
+		Roles:       []string{"admin"},
+		Permissions: []string{"users:delete"},
+	}
+
+	tests := []struct {
+		name string
+		req  Requirement
+		want bool
+		str  string
+	}{
+		{"scope", Scope("read"), true, "scope(read)"},
+		{"missing scope", Scope("write"), false, "scope(write)"},
+		{"role", Role("admin"), true, "role(admin)"},
+		{"permission", Permission("users:delete"), true, "permission(users:delete)"},
+		{"all", RequireAll(Scope("read"), Role("admin")), true, "(scope(read) AND role(admin))"},
+		{"all missing one", RequireAll(Scope("read"), Scope("write")), false, "(scope(read) AND scope(write))"},
+		{"any", RequireAny(Scope("write"), Role("admin")), true, "(scope(write) OR role(admin))"},
+		{"any missing all", RequireAny(Scope("write"), Role("user")), false, "(scope(write) OR role(user))"},
+		{"empty all", RequireAll(), true, "()"},
+		{"empty any", RequireAny(), true, "()"},
+		{"nested", RequireAll(Scope("read"), RequireAny(Role("user"), Permission("users:delete"))), true, "(scope(read) AND (role(user) OR permission(users:delete)))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.Allow(p); got != tt.want {
+				t.Errorf("Allow() = %v, want %v", got, tt.want)
+			}
+			if got := tt.req.String(); got != tt.str {
+				t.Errorf("String() = %q, want %q", got, tt.str)
+			}
+		})
+	}
+}
+
+func TestWithRequirements(t *testing.T) {
+	principal := func(p *Principal) Authorizer {
+		return func(r *http.Request) (context.Context, error) {
+			return ContextWithPrincipal(r.Context(), p), nil
+		}
+	}
+
+	tests := []struct {
+		name       string
+		opts       []RouteOption
+		wantStatus int
+	}{
+		{
+			name:       "unauthenticated",
+			opts:       []RouteOption{WithScopes("read")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "forbidden",
+			opts:       []RouteOption{WithAuthorizers(principal(&Principal{Scopes: []string{"read"}})), WithScopes("write")},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "allowed",
+			opts:       []RouteOption{WithAuthorizers(principal(&Principal{Roles: []string{"admin"}})), WithRoles("admin")},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithBasepath("/"))
+
+			s.AddRoute("/test", func(ctx context.Context) Responder {
+				return NewResponse("ok")
+			}, tt.opts...)
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRequirementMetadata(t *testing.T) {
+	opt := &routeOption{metadata: make(map[string]interface{})}
+
+	WithRequirements(RequireAny(Scope("read"), Role("admin")), Scope("read"))(opt)
+
+	if got, want := opt.metadata["scopes"], []string{"read"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scopes = %v, want %v", got, want)
+	}
+
+	if got, want := opt.metadata["roles"], []string{"admin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("roles = %v, want %v", got, want)
+	}
+
+	err := opt.requires.authorize(httptest.NewRequest("GET", "/", nil).WithContext(
+		ContextWithPrincipal(context.Background(), &Principal{Roles: []string{"admin"}})))
+
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("authorize() = %v, want %v", err, ErrForbidden)
+	}
+}
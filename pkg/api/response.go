@@ -94,7 +94,8 @@ func (r *Response) WithHeaders(h map[string]interface{}) *Response {
 	return r
 }
 
-// Redirect will set the proper redirect headers and http.StatusFound
+// Redirect will set the proper redirect headers and http.StatusFound, a relative url is made
+// absolute using the url the client requested when the response is written
 func Redirect(u *url.URL, args ...map[string]string) *Response {
 	r := NewResponse()
 
@@ -129,8 +130,16 @@ func (r *Response) Payload() interface{} {
 	return r.payload
 }
 
-// Write writes the response to the writer
+// Write writes the response to the writer, a relative Location header is resolved against the url
+// the client requested, see RequestURL
 func (r *Response) Write(w http.ResponseWriter, req *http.Request) error {
+	// relative redirects are resolved against the url the client requested
+	if loc := r.header.Get("Location"); loc != "" {
+		if u, err := url.Parse(loc); err == nil && !u.IsAbs() {
+			r.header.Set("Location", requestURL(req).ResolveReference(u).String())
+		}
+	}
+
 	if len(r.header) > 0 {
 		for key, vals := range r.header {
 			for _, val := range vals {
@@ -0,0 +1,241 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/blang/semver/v4"
+)
+
+type (
+	// route is a registered path and method, a route may have a handler per version range
+	route struct {
+		path     string
+		method   string
+		handlers []*routeHandler
+		lock     sync.RWMutex
+	}
+
+	// routeHandler is a route handler and the versions it serves
+	routeHandler struct {
+		handler    http.Handler
+		introduced *semver.Version
+		removed    *semver.Version
+		versions   semver.Range
+		opt        *routeOption
+	}
+)
+
+var (
+	// ErrRouteVersion is returned when the route does not exist in the requested version
+	ErrRouteVersion = errors.New("route not available in the requested version")
+)
+
+// handle registers the handler for the path and methods, handlers added for an existing route
+// are selected by the requested version; a duplicate unversioned handler is logged and ignored as
+// the first registration is served
+func (s *Server) handle(path string, opt *routeOption, h http.Handler) {
+	rh := &routeHandler{
+		handler: h,
+		opt:     opt,
+	}
+
+	if opt.introduced != "" {
+		v := mustParseRouteVersion(opt.introduced)
+		rh.introduced = &v
+	}
+
+	if opt.removed != "" {
+		v := mustParseRouteVersion(opt.removed)
+		rh.removed = &v
+	}
+
+	if opt.versionRange != "" {
+		r, err := semver.ParseRange(opt.versionRange)
+		if err != nil {
+			panic(fmt.Sprintf("api: invalid route version range %q: %s", opt.versionRange, err))
+		}
+		rh.versions = r
+	}
+
+	s.routeLock.Lock()
+	defer s.routeLock.Unlock()
+
+	for _, method := range opt.methods {
+		rt := s.route(path, method)
+
+		rt.lock.Lock()
+		if rh.unversioned() && rt.unversioned() {
+			s.log.Warnf("route %s %s is already registered, the first handler is used", method, path)
+		}
+		rt.handlers = append(rt.handlers, rh)
+		rt.lock.Unlock()
+	}
+}
+
+// route returns the registered route for the path and method, adding it to the router if it does
+// not exist; the route lock must be held
+func (s *Server) route(path, method string) *route {
+	key := method + " " + path
+
+	if rt, ok := s.routes[key]; ok {
+		return rt
+	}
+
+	rt := &route{
+		path:   path,
+		method: method,
+	}
+
+	// OPTIONS requests for the path are answered by the options handler
+	if len(s.pathMethods(path)) == 0 {
+		s.apiRouter.Handle(path, s.optionsHandler(path)).Methods(http.MethodOptions)
+	}
+
+	// HEAD requests are answered by the HEAD route or the GET route without a body
+	headless := s.routes[http.MethodGet+" "+path] == nil && s.routes[http.MethodHead+" "+path] == nil
+
+	s.routes[key] = rt
+
+	switch method {
+	case http.MethodOptions:
+	case http.MethodGet, http.MethodHead:
+		if headless {
+			s.apiRouter.Handle(path, s.headHandler(path)).Methods(http.MethodHead)
+		}
+		if method == http.MethodGet {
+			s.apiRouter.Handle(path, s.routeDispatcher(rt)).Methods(method)
+		}
+	default:
+		s.apiRouter.Handle(path, s.routeDispatcher(rt)).Methods(method)
+	}
+
+	return rt
+}
+
+// routeDispatcher calls the handler that best matches the requested version
+func (s *Server) routeDispatcher(rt *route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ver := s.requestSemver(r)
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyVersion, ver))
+
+		h := rt.match(ver)
+
+		if p := s.corsPolicy(h); p != nil {
+			p.handle(w, r)
+		}
+
+		if h != nil {
+			if d := s.deprecation(h, ver); d != nil && !s.deprecate(w, r, rt, d, ver) {
+				return
+			}
+
+			h.handler.ServeHTTP(w, r)
+			return
+		}
+
+		s.WriteError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrRouteVersion, ver))
+	})
+}
+
+// match returns the handler serving the version, the handler introduced most recently wins and
+// earlier registrations win ties
+func (rt *route) match(v semver.Version) *routeHandler {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+
+	var best *routeHandler
+
+	for _, h := range rt.handlers {
+		if !h.serves(v) {
+			continue
+		}
+
+		if best == nil || h.since().GT(best.since()) {
+			best = h
+		}
+	}
+
+	return best
+}
+
+// unversioned returns true if the route has a handler for all versions, the lock must be held
+func (rt *route) unversioned() bool {
+	for _, h := range rt.handlers {
+		if h.unversioned() {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *routeHandler) unversioned() bool {
+	return h.introduced == nil && h.removed == nil && h.versions == nil
+}
+
+func (h *routeHandler) serves(v semver.Version) bool {
+	if h.introduced != nil && v.LT(*h.introduced) {
+		return false
+	}
+
+	if h.removed != nil && v.GTE(*h.removed) {
+		return false
+	}
+
+	if h.versions != nil && !h.versions(v) {
+		return false
+	}
+
+	return true
+}
+
+func (h *routeHandler) since() semver.Version {
+	if h.introduced != nil {
+		return *h.introduced
+	}
+	return semver.Version{}
+}
+
+func mustParseRouteVersion(v string) semver.Version {
+	ver, err := semver.ParseTolerant(v)
+	if err != nil {
+		panic(fmt.Sprintf("api: invalid route version %q: %s", v, err))
+	}
+	return ver
+}
+
+// WithIntroduced sets the version the route handler was introduced in, requests for earlier
+// versions are not served by the handler; an invalid version panics when the route is added
+func WithIntroduced(version string) RouteOption {
+	return func(r *routeOption) {
+		r.introduced = version
+	}
+}
+
+// WithRemoved sets the version the route handler was removed in, requests for this version and
+// later are not served by the handler; an invalid version panics when the route is added
+func WithRemoved(version string) RouteOption {
+	return func(r *routeOption) {
+		r.removed = version
+	}
+}
+
+// WithVersionRange restricts the route handler to a semver range, i.e. ">=1.2.0 <2.0.0"; an
+// invalid range panics when the route is added
+func WithVersionRange(versions string) RouteOption {
+	return func(r *routeOption) {
+		r.versionRange = versions
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"github.com/blang/semver/v4"
+)
+
+func TestRouteMatch(t *testing.T) {
+	s := NewServer(WithBasepath("/"))
+
+	handlers := []struct {
+		name string
+		opts []RouteOption
+	}{
+		{"v1", []RouteOption{WithRemoved("2.0.0")}},
+		{"v2", []RouteOption{WithIntroduced("2.0.0")}},
+		{"v2-duplicate", []RouteOption{WithIntroduced("2.0.0")}},
+		{"v3", []RouteOption{WithIntroduced("3.0.0"), WithRemoved("4.0.0")}},
+	}
+
+	for _, h := range handlers {
+		name := h.name
+		opt := &routeOption{methods: []string{"GET"}}
+		for _, o := range h.opts {
+			o(opt)
+		}
+
+		s.handle("/items", opt, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+
+	rt := s.routes["GET /items"]
+
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{version: "1.0.0", want: "v1"},
+		{version: "1.9.9", want: "v1"},
+		{version: "2.0.0", want: "v2"},
+		{version: "3.1.0", want: "v3"},
+		{version: "4.0.0", want: "v2"},
+		{version: "5.0.0", want: "v2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			h := rt.match(semver.MustParse(tt.version))
+			if h == nil {
+				t.Fatal("no handler matched")
+			}
+
+			w := httptest.NewRecorder()
+			h.handler.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("match() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateRoute(t *testing.T) {
+	logs := memory.New()
+
+	s := NewServer(WithBasepath("/"), WithLog(&log.Logger{Handler: logs, Level: log.InfoLevel}))
+
+	s.AddRoute("/items", func(ctx context.Context) Responder { return NewResponse("first") })
+	s.AddRoute("/items", func(ctx context.Context) Responder { return NewResponse("second") })
+	s.AddRoute("/items", func(ctx context.Context) Responder { return NewResponse("v2") }, WithIntroduced("2.0.0"))
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/items", nil))
+
+	if body, _ := ioutil.ReadAll(w.Body); string(body) != "first" {
+		t.Errorf("body = %q, want the first handler", body)
+	}
+
+	if len(logs.Entries) != 1 || logs.Entries[0].Level != log.WarnLevel {
+		t.Errorf("logged %d entries, want a single duplicate warning", len(logs.Entries))
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -27,13 +28,13 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/allegro/bigcache"
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/discard"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"github.com/spf13/cast"
@@ -45,30 +46,67 @@ type (
 
 	// Server is an http server that provides basic REST funtionality
 	Server struct {
-		log           log.Interface
-		router        *mux.Router
-		apiRouter     *mux.Router
-		addr          string
-		listener      net.Listener
-		srv           *http.Server
-		lock          sync.Mutex
-		basePath      string
-		name          string
-		version       string
-		serverVersion string
-		versioning    bool
-		corsOrigin    []string
-		cache         *bigcache.BigCache
-		cacheTTL      time.Duration
+		log                 log.Interface
+		router              *mux.Router
+		apiRouter           *mux.Router
+		handler             http.Handler
+		middleware          []Middleware
+		apiMiddleware       []Middleware
+		notFound            http.Handler
+		methodNotAllowed    http.Handler
+		addr                string
+		listener            net.Listener
+		srv                 *http.Server
+		lock                sync.Mutex
+		basePath            string
+		name                string
+		version             string
+		serverVersion       string
+		versioning          bool
+		versionStrategies   []VersionStrategy
+		cors                *CORSPolicy
+		cache               *bigcache.BigCache
+		cacheTTL            time.Duration
+		health              *health
+		lifecycle           *lifecycle
+		tls                 *tlsOptions
+		http2               *http2Options
+		limits              limits
+		csrf                *csrfOptions
+		rateLimits          []rateLimit
+		concurrency         *ConcurrencyLimiter
+		proxies             *proxyOptions
+		routes              map[string]*route
+		routeLock           sync.Mutex
+		routeInfos          []RouteInfo
+		routesPath          string
+		routesAuthorizers   []Authorizer
+		deprecations        *deprecationTracker
+		versionDeprecations []versionDeprecation
 	}
 
 	routeOption struct {
-		method      string
-		params      interface{}
-		validate    bool
-		contextFunc ContextFunc
-		authorizers []Authorizer
-		cache       bool
+		methods       []string
+		params        interface{}
+		validate      bool
+		contextFunc   ContextFunc
+		authorizers   []Authorizer
+		cache         bool
+		timeout       time.Duration
+		timeoutStatus int
+		requires      *requirementSet
+		metadata      map[string]interface{}
+		browser       bool
+		rateLimits    []rateLimit
+		concurrency   *ConcurrencyLimiter
+		priority      Priority
+		introduced    string
+		removed       string
+		versionRange  string
+		deprecation   *Deprecation
+		changes       []VersionChange
+		cors          *CORSPolicy
+		middleware    []Middleware
 	}
 
 	// RouteOption defines route options
@@ -119,14 +157,48 @@ func NewServer(opts ...Option) *Server {
 		name:       defaultName,
 		version:    defaultVersion,
 		versioning: false,
-		basePath:   defaultBasePath,
-		cacheTTL:   defaultCacheTTL,
+		versionStrategies: []VersionStrategy{
+			PathVersion("version"),
+		},
+		basePath:     defaultBasePath,
+		cacheTTL:     defaultCacheTTL,
+		health:       newHealth(),
+		lifecycle:    newLifecycle(),
+		http2:        &http2Options{},
+		csrf:         newCSRFOptions(),
+		proxies:      &proxyOptions{},
+		routes:       make(map[string]*route),
+		deprecations: newDeprecationTracker(),
+		limits: limits{
+			readHeaderTimeout: defaultReadHeaderTimeout,
+		},
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
 
+	if s.health.livenessPath != "" {
+		s.router.HandleFunc(s.health.livenessPath, s.healthHandler(true)).Methods(http.MethodGet, http.MethodHead)
+	}
+
+	if s.health.readinessPath != "" {
+		s.router.HandleFunc(s.health.readinessPath, s.healthHandler(false)).Methods(http.MethodGet, http.MethodHead)
+	}
+
+	if s.routesPath != "" {
+		s.router.HandleFunc(s.routesPath, s.routesHandler).Methods(http.MethodGet)
+	}
+
+	// handlers set on a router from WithRouter are kept
+	if s.router.NotFoundHandler == nil || s.notFound != nil {
+		s.router.NotFoundHandler = s.notFoundHandler()
+	}
+
+	if s.router.MethodNotAllowedHandler == nil || s.methodNotAllowed != nil {
+		s.router.MethodNotAllowedHandler = s.methodNotAllowedHandler()
+	}
+
 	s.apiRouter = s.router.PathPrefix(s.basePath).Subrouter()
 
 	s.apiRouter.Use(s.LogMiddleware())
@@ -135,6 +207,12 @@ func NewServer(opts ...Option) *Server {
 		s.apiRouter.Use(s.versionMiddleware())
 	}
 
+	for _, mw := range s.apiMiddleware {
+		s.apiRouter.Use(mux.MiddlewareFunc(mw))
+	}
+
+	s.handler = s.buildHandler()
+
 	s.cache, _ = bigcache.NewBigCache(bigcache.DefaultConfig(s.cacheTTL))
 
 	return s
@@ -143,48 +221,30 @@ func NewServer(opts ...Option) *Server {
 // Serve starts the http server
 func (s *Server) Serve() error {
 	var listener net.Listener
+	var tlsConfig *tls.Config
 	var err error
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	if s.srv != nil {
-		return errors.New("server already running")
-	}
-
-	handler := http.Handler(s.router)
-
-	if len(s.corsOrigin) > 0 {
-		handler = handlers.CORS(
-			handlers.AllowedOrigins(s.corsOrigin),
-			handlers.AllowedMethods([]string{"OPTIONS", "HEAD", "GET", "POST", "PUT", "DELETE"}),
-			handlers.ExposedHeaders([]string{
-				"X-Total-Count",
-				"X-Atom-Link",
-				"X-Last-Entry-Date",
-				"Server",
-				"Content-Length",
-				"Content-Range",
-				"Content-Encoding",
-			}),
-			handlers.AllowedHeaders([]string{
-				"Accept",
-				"Accept-Language",
-				"Authorization",
-				"Content-Type",
-				"Content-Language",
-				"Origin",
-				"Range",
-				"If-Modified-Since",
-				"X-Forwarded-For",
-				"X-Original-Method",
-				"X-Redirected-From"}),
-			handlers.AllowCredentials(),
-		)(handler)
-	}
-
-	s.srv = &http.Server{
-		Handler: handler,
+		return ErrServerRunning
+	}
+
+	if s.tls != nil {
+		if tlsConfig, err = s.tls.config(s); err != nil {
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		TLSConfig: tlsConfig,
+	}
+
+	s.limits.apply(srv)
+
+	if srv.Handler, err = s.http2.configure(srv, s.handler); err != nil {
+		return err
 	}
 
 	if s.listener != nil {
@@ -198,58 +258,132 @@ func (s *Server) Serve() error {
 		return errors.New("server address not set")
 	}
 
+	listener = s.limits.listener(listener)
+
+	s.lifecycle.reset()
+
+	s.srv = srv
+
+	atomic.StoreInt32(&s.health.draining, 0)
+
+	serveErr := s.lifecycle.serveErr
+
 	go func() {
-		if err := s.srv.Serve(listener); err != nil && err != http.ErrServerClosed {
-			s.log.Fatalf("listen: %s\n", err)
+		var err error
+
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(listener, "", "")
+		} else {
+			err = srv.Serve(listener)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			s.log.Errorf("listen: %s", err)
+
+			serveErr <- err
 		}
 	}()
 
-	s.log.Debugf("http server listening on: %s", s.addr)
+	s.log.Debugf("http server listening on: %s", listener.Addr())
 
 	return nil
 }
 
-// Shutdown shuts down the http server with the context
+// Shutdown gracefully shuts down the http server with the context; readiness fails immediately,
+// the pre-shutdown hooks are called, and after the drain delay the listener is closed, long-lived
+// connections are notified and in-flight requests are waited on before the post-shutdown hooks
 func (s *Server) Shutdown(ctx context.Context) error {
+	var errs MultiError
+
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	if s.srv == nil {
-		s.log.Fatal("server already shutdown")
+		return ErrServerNotRunning
+	}
+
+	// fail readiness first so load balancers stop routing traffic
+	atomic.StoreInt32(&s.health.draining, 1)
+
+	for _, h := range s.lifecycle.preShutdown {
+		if err := h(ctx); err != nil {
+			s.log.Errorf("pre-shutdown hook: %s", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if s.lifecycle.drainDelay > 0 {
+		select {
+		case <-time.After(s.lifecycle.drainDelay):
+		case <-ctx.Done():
+		}
 	}
 
-	err := s.srv.Shutdown(ctx)
+	s.lifecycle.notify()
+
+	if err := s.srv.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	} else if err := s.lifecycle.wait(ctx); err != nil {
+		errs = append(errs, err)
+	}
 
 	s.srv = nil
 
-	return err
+	for _, h := range s.lifecycle.postShutdown {
+		if err := h(ctx); err != nil {
+			s.log.Errorf("post-shutdown hook: %s", err)
+			errs = append(errs, err)
+		}
+	}
+
+	s.lifecycle.finish()
+
+	return errs.ErrorOrNil()
 }
 
-// Handler returns the server http handler
+// Handler returns the server http handler with the full middleware stack, this is the same
+// handler used by Serve
 func (s *Server) Handler() http.Handler {
-	return s.router
+	return s.handler
 }
 
-// Router returns the server router
+// Router returns the server router, requests served directly by the router bypass the global
+// middleware; use Handler to embed the server
 func (s *Server) Router() *mux.Router {
 	return s.router
 }
 
+// ServeHTTP implements http.Handler using the server handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
 }
 
-// AddRoute adds a route in the clear
+// AddRoute adds a route in the clear; adding a route again for the same path and method with
+// different versions (see WithIntroduced) registers a handler for those versions
 func (s *Server) AddRoute(path string, handler interface{}, opts ...RouteOption) {
 	opt := &routeOption{
-		method: http.MethodGet,
+		methods:  []string{http.MethodGet},
+		metadata: make(map[string]interface{}),
 	}
 
 	for _, o := range opts {
 		o(opt)
 	}
 
-	s.apiRouter.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+	authorizer := AllOf(opt.authorizers...)
+
+	pipeline := newVersionPipeline(opt.changes)
+
+	// global limits are checked before route limits, route limits are scoped to the method and path
+	limits := make([]rateLimit, 0, len(s.rateLimits)+len(opt.rateLimits))
+	limits = append(limits, s.rateLimits...)
+
+	for _, l := range opt.rateLimits {
+		l.scope = strings.Join(opt.methods, ",") + " " + path
+		limits = append(limits, l)
+	}
+
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var resp interface{}
 
 		cache := opt.cache
@@ -264,6 +398,10 @@ func (s *Server) AddRoute(path string, handler interface{}, opts ...RouteOption)
 
 		r = r.WithContext(context.WithValue(r.Context(), contextKeyRequest, rc))
 
+		// add the verified client certificate identity for authorizers
+		r = r.WithContext(peerIdentityContext(r))
+		rc.r = r
+
 		defer func() {
 			if err := recover(); err != nil {
 				debug.PrintStack()
@@ -279,6 +417,14 @@ func (s *Server) AddRoute(path string, handler interface{}, opts ...RouteOption)
 
 			switch t := resp.(type) {
 			case Responder:
+				if rs, ok := t.(*Response); ok && len(pipeline) > 0 {
+					if err := pipeline.response(r.Context(), rs); err != nil {
+						s.log.Error(err.Error())
+						s.WriteError(w, http.StatusInternalServerError, err)
+						return
+					}
+				}
+
 				if cache || trace {
 					rec := httptest.NewRecorder()
 
@@ -328,26 +474,67 @@ func (s *Server) AddRoute(path string, handler interface{}, opts ...RouteOption)
 			}
 		}()
 
-		if len(opt.authorizers) > 0 && opt.authorizers[0] != nil {
-			for _, a := range opt.authorizers {
-				ctx, err := a(r)
-				if err != nil {
-					if r, ok := err.(Responder); ok {
-						resp = r
-					} else {
-						s.log.Error(err.Error())
-						s.WriteError(w, http.StatusUnauthorized, err)
-					}
-					return
-				}
+		var authErr error
 
+		if authorizer != nil {
+			ctx, err := authorizer(r)
+			if err != nil {
+				authErr = err
+			} else if ctx != nil {
 				// add the auth context to the context
-				if ctx != nil {
-					r = r.WithContext(ctx)
+				r = r.WithContext(ctx)
+
+				// authorizers may read the body from a copy of the request, i.e. within AllOf
+				if data := RequestBody(ctx); data != nil {
+					r.Body = ioutil.NopCloser(bytes.NewReader(data))
 				}
 			}
 		}
 
+		// rate limits are checked before auth errors are returned so failed attempts count
+		if len(limits) > 0 {
+			if err := s.rateLimit(w, r, limits); err != nil {
+				s.WriteError(w, http.StatusTooManyRequests, err)
+				return
+			}
+		}
+
+		if authErr != nil {
+			if r, ok := authErr.(Responder); ok {
+				resp = r
+			} else {
+				s.log.Error(authErr.Error())
+				s.WriteError(w, http.StatusUnauthorized, authErr)
+			}
+			return
+		}
+
+		if opt.requires != nil {
+			if err := opt.requires.authorize(r); err != nil {
+				resp = err
+				return
+			}
+		}
+
+		if opt.browser {
+			var err error
+
+			if r, err = s.csrf.protect(w, r); err != nil {
+				s.WriteError(w, http.StatusForbidden, err)
+				return
+			}
+			rc.r = r
+		}
+
+		// migrate the request to the latest version before the handler sees it
+		if len(pipeline) > 0 {
+			if err := pipeline.request(r); err != nil {
+				s.log.Error(err.Error())
+				s.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
 		if cache {
 			if val, err := s.cache.Get(r.RequestURI); err == nil {
 				resp, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(val)), r)
@@ -527,7 +714,34 @@ func (s *Server) AddRoute(path string, handler interface{}, opts ...RouteOption)
 			resp = rval[0].Interface()
 		}
 
-	}).Methods(opt.method)
+	})
+
+	limiters := make([]*ConcurrencyLimiter, 0, 2)
+
+	for _, l := range []*ConcurrencyLimiter{s.concurrency, opt.concurrency} {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+
+	// the route timeout includes time spent waiting in the queue
+	if len(limiters) > 0 && opt.priority < PriorityCritical {
+		h = s.concurrencyHandler(h, limiters, opt.priority)
+	}
+
+	if opt.timeout > 0 {
+		h = s.timeoutHandler(h, opt.timeout, opt.timeoutStatus)
+	}
+
+	h = chain(h, opt.middleware)
+
+	info := s.routeInfo(path, handler, opt)
+
+	s.handle(path, opt, h)
+
+	s.routeLock.Lock()
+	s.routeInfos = append(s.routeInfos, info)
+	s.routeLock.Unlock()
 }
 
 // WriteJSON writes out json
@@ -572,23 +786,11 @@ func WithLog(l log.Interface) Option {
 	}
 }
 
-// WithCORS sets the cors origin and enables cors on the router
-func WithCORS(origin ...string) Option {
-	return func(s *Server) {
-		s.corsOrigin = origin
-	}
-}
-
-// WithRouter specifies the router to use
+// WithRouter specifies the router to use, the api routes and middleware are added to it
 func WithRouter(router *mux.Router) Option {
 	return func(s *Server) {
 		if router != nil {
 			s.router = router
-			s.apiRouter = s.router.PathPrefix(s.basePath).Subrouter()
-
-			if s.versioning {
-				s.apiRouter.Use(s.versionMiddleware())
-			}
 		}
 	}
 }
@@ -617,7 +819,8 @@ func WithBasepath(base string) Option {
 }
 
 // WithVersioning enables versioning that will enforce a versioned path
-// and optionally set the Server header to the serverVersion
+// and optionally set the Server header to the serverVersion; use
+// WithVersionStrategy to select the version from headers or the query
 func WithVersioning(version string, serverVersion ...string) Option {
 	return func(s *Server) {
 		s.versioning = true
@@ -631,6 +834,14 @@ func WithVersioning(version string, serverVersion ...string) Option {
 	}
 }
 
+// WithVersionStrategy sets the strategies used to select the requested version, they are
+// tried in order and requests that do not specify a version get the server version
+func WithVersionStrategy(strategies ...VersionStrategy) Option {
+	return func(s *Server) {
+		s.versionStrategies = strategies
+	}
+}
+
 // WithName specifies the server name
 func WithName(name string) Option {
 	return func(s *Server) {
@@ -648,7 +859,17 @@ func WithCache(ttl time.Duration) Option {
 // WithMethod sets the method for the route option
 func WithMethod(m string) RouteOption {
 	return func(r *routeOption) {
-		r.method = m
+		r.methods = []string{strings.ToUpper(m)}
+	}
+}
+
+// WithMethods sets the methods for the route option, the handler is registered for each method
+func WithMethods(m ...string) RouteOption {
+	return func(r *routeOption) {
+		r.methods = make([]string, 0, len(m))
+		for _, v := range m {
+			r.methods = append(r.methods, strings.ToUpper(v))
+		}
 	}
 }
 
@@ -673,7 +894,8 @@ func WithValidation(v bool) RouteOption {
 	}
 }
 
-// WithAuthorizers sets the authorizers
+// WithAuthorizers sets the route authorizers, all of the authorizers must succeed; use AnyOf
+// and Optional to compose alternative or optional authentication
 func WithAuthorizers(a ...Authorizer) RouteOption {
 	return func(r *routeOption) {
 		r.authorizers = a
@@ -710,28 +932,17 @@ func Request(ctx context.Context) (*http.Request, http.ResponseWriter) {
 	return nil, nil
 }
 
-// RequestHost returns the host from the current request
+// RequestHost returns the host the client requested, trusted proxy headers are honored
 func RequestHost(ctx context.Context) string {
-	r, _ := Request(ctx)
-
-	if r == nil {
-		return ""
-	}
-
-	if r.URL.Hostname() != "" {
-		return r.URL.Hostname()
-	}
-
-	u := url.URL{
-		Host: r.Host,
-	}
-
-	return u.Hostname()
+	return RequestClient(ctx).Host
 }
 
-// RequestBody returns the raw request body
+// RequestBody returns the raw request body, or nil if the body has not been read
 func RequestBody(ctx context.Context) []byte {
-	return ctx.Value(contextKeyBody).([]byte)
+	if data, ok := ctx.Value(contextKeyBody).([]byte); ok {
+		return data
+	}
+	return nil
 }
 
 // Log returns the server log
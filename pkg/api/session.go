@@ -0,0 +1,523 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+type (
+	// Session is a browser session, changes must be saved with Save before the response is written
+	Session struct {
+		// ID is the session id, it is regenerated on Login
+		ID string `json:"id"`
+
+		// Values are the session values, they must be json serializable
+		Values map[string]interface{} `json:"values,omitempty"`
+
+		// Principal is the authenticated principal of the session
+		Principal *Principal `json:"principal,omitempty"`
+
+		// CSRFToken is the synchronizer token for the session
+		CSRFToken string `json:"csrf,omitempty"`
+
+		// CreatedAt is the session creation time in unix seconds
+		CreatedAt int64 `json:"created_at"`
+
+		isNew     bool
+		destroyed bool
+		store     SessionStore
+	}
+
+	// SessionStore loads and saves sessions
+	SessionStore interface {
+		// Load returns the request session, or a new session if there is none or it is invalid
+		Load(r *http.Request) (*Session, error)
+
+		// Save saves the session, setting the session cookie on the response
+		Save(w http.ResponseWriter, r *http.Request, s *Session) error
+	}
+
+	// SessionKey is a cookie key, Hash authenticates the cookie and the optional Block key
+	// (16, 24 or 32 bytes) encrypts it with AES
+	SessionKey struct {
+		Hash  []byte
+		Block []byte
+	}
+
+	// SessionOption defines session store options
+	SessionOption func(*sessionOptions)
+
+	// SessionAuthorizerOption defines session authorizer options
+	SessionAuthorizerOption func(*sessionAuthorizer)
+
+	sessionOptions struct {
+		name     string
+		path     string
+		domain   string
+		maxAge   time.Duration
+		secure   bool
+		sameSite http.SameSite
+	}
+
+	// cookieStore stores the entire session in the cookie
+	cookieStore struct {
+		opts   *sessionOptions
+		codecs []securecookie.Codec
+	}
+
+	// memoryStore stores the session server side, the cookie contains the signed session id
+	memoryStore struct {
+		opts     *sessionOptions
+		codecs   []securecookie.Codec
+		sessions map[string]*memorySession
+		pruned   time.Time
+		lock     sync.Mutex
+	}
+
+	memorySession struct {
+		data    []byte
+		expires time.Time
+	}
+
+	sessionAuthorizer struct {
+		store     SessionStore
+		anonymous bool
+	}
+)
+
+const (
+	defaultSessionName   = "session"
+	defaultSessionMaxAge = time.Hour * 24 * 7
+
+	memoryStorePruneInterval = time.Minute
+)
+
+var (
+	// ErrSessionMissing is returned when the session is not authenticated
+	ErrSessionMissing = fmt.Errorf("%w: session", ErrCredentialsMissing)
+
+	// ErrSessionKeyRequired is returned when a store is created without keys
+	ErrSessionKeyRequired = errors.New("at least one session key is required")
+
+	// ErrSessionBlockKeyRequired is returned when an encrypted store key has no block key
+	ErrSessionBlockKeyRequired = errors.New("session block key required for encryption")
+
+	contextKeySession = contextKey("session")
+)
+
+func newSessionOptions(opts ...SessionOption) *sessionOptions {
+	o := &sessionOptions{
+		name:     defaultSessionName,
+		path:     "/",
+		maxAge:   defaultSessionMaxAge,
+		secure:   true,
+		sameSite: http.SameSiteLaxMode,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+func newSessionCodecs(o *sessionOptions, keys []SessionKey) ([]securecookie.Codec, error) {
+	if len(keys) == 0 {
+		return nil, ErrSessionKeyRequired
+	}
+
+	codecs := make([]securecookie.Codec, 0, len(keys))
+
+	for _, k := range keys {
+		c := securecookie.New(k.Hash, k.Block)
+		c.SetSerializer(securecookie.JSONEncoder{})
+		c.MaxAge(int(o.maxAge.Seconds()))
+
+		codecs = append(codecs, c)
+	}
+
+	return codecs, nil
+}
+
+// cookie returns the session cookie with the value, an empty value expires the cookie
+func (o *sessionOptions) cookie(value string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     o.name,
+		Value:    value,
+		Path:     o.path,
+		Domain:   o.domain,
+		Secure:   o.secure,
+		HttpOnly: true,
+		SameSite: o.sameSite,
+	}
+
+	if value == "" {
+		c.MaxAge = -1
+		c.Expires = time.Unix(1, 0)
+	} else if o.maxAge > 0 {
+		c.MaxAge = int(o.maxAge.Seconds())
+		c.Expires = time.Now().Add(o.maxAge)
+	}
+
+	return c
+}
+
+// NewSession returns a new unsaved session for the store
+func NewSession(store SessionStore) *Session {
+	return &Session{
+		ID:        randomToken(32),
+		Values:    make(map[string]interface{}),
+		CSRFToken: randomToken(32),
+		CreatedAt: time.Now().Unix(),
+		isNew:     true,
+		store:     store,
+	}
+}
+
+// Get returns the session value
+func (s *Session) Get(key string) interface{} {
+	return s.Values[key]
+}
+
+// Set sets the session value
+func (s *Session) Set(key string, value interface{}) {
+	s.Values[key] = value
+}
+
+// Delete removes the session value
+func (s *Session) Delete(key string) {
+	delete(s.Values, key)
+}
+
+// IsNew returns true if the session was created by this request
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Login sets the session principal, the session id and csrf token are regenerated to prevent
+// session fixation
+func (s *Session) Login(p *Principal) {
+	s.Principal = p
+	s.ID = randomToken(32)
+	s.CSRFToken = randomToken(32)
+}
+
+// Destroy clears the session, the cookie is expired when the session is saved
+func (s *Session) Destroy() {
+	s.destroyed = true
+	s.Principal = nil
+	s.Values = make(map[string]interface{})
+}
+
+// Save saves the session using the request and response writer from the context
+func (s *Session) Save(ctx context.Context) error {
+	r, w := Request(ctx)
+	if r == nil || w == nil {
+		return errors.New("request context not found")
+	}
+
+	return s.store.Save(w, r, s)
+}
+
+// NewSignedCookieStore returns a SessionStore that stores the session in an hmac signed cookie;
+// the first key is used to sign and all keys are tried when verifying to support key rotation
+func NewSignedCookieStore(keys [][]byte, opts ...SessionOption) (SessionStore, error) {
+	sk := make([]SessionKey, 0, len(keys))
+
+	for _, k := range keys {
+		sk = append(sk, SessionKey{Hash: k})
+	}
+
+	return newCookieStore(sk, opts...)
+}
+
+// NewEncryptedCookieStore returns a SessionStore that stores the session in a signed and AES
+// encrypted cookie; the first key is used to encode and all keys are tried when decoding
+func NewEncryptedCookieStore(keys []SessionKey, opts ...SessionOption) (SessionStore, error) {
+	for _, k := range keys {
+		if len(k.Block) == 0 {
+			return nil, ErrSessionBlockKeyRequired
+		}
+	}
+
+	return newCookieStore(keys, opts...)
+}
+
+func newCookieStore(keys []SessionKey, opts ...SessionOption) (SessionStore, error) {
+	o := newSessionOptions(opts...)
+
+	codecs, err := newSessionCodecs(o, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cookieStore{
+		opts:   o,
+		codecs: codecs,
+	}, nil
+}
+
+func (c *cookieStore) Load(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(c.opts.name)
+	if err != nil {
+		return NewSession(c), nil
+	}
+
+	s := &Session{}
+
+	if err := securecookie.DecodeMulti(c.opts.name, cookie.Value, s, c.codecs...); err != nil {
+		return NewSession(c), nil
+	}
+
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+
+	s.store = c
+
+	return s, nil
+}
+
+func (c *cookieStore) Save(w http.ResponseWriter, r *http.Request, s *Session) error {
+	if s.destroyed {
+		http.SetCookie(w, c.opts.cookie(""))
+		return nil
+	}
+
+	value, err := securecookie.EncodeMulti(c.opts.name, s, c.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, c.opts.cookie(value))
+
+	return nil
+}
+
+// NewMemorySessionStore returns a server side SessionStore, the cookie contains only the signed
+// session id; sessions are lost when the process exits
+func NewMemorySessionStore(keys [][]byte, opts ...SessionOption) (SessionStore, error) {
+	o := newSessionOptions(opts...)
+
+	sk := make([]SessionKey, 0, len(keys))
+
+	for _, k := range keys {
+		sk = append(sk, SessionKey{Hash: k})
+	}
+
+	codecs, err := newSessionCodecs(o, sk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryStore{
+		opts:     o,
+		codecs:   codecs,
+		sessions: make(map[string]*memorySession),
+	}, nil
+}
+
+func (m *memoryStore) Load(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.opts.name)
+	if err != nil {
+		return NewSession(m), nil
+	}
+
+	var id string
+
+	if err := securecookie.DecodeMulti(m.opts.name, cookie.Value, &id, m.codecs...); err != nil {
+		return NewSession(m), nil
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(m.pruned) > memoryStorePruneInterval {
+		m.prune(now)
+	}
+
+	ms, ok := m.sessions[id]
+	if !ok || now.After(ms.expires) {
+		return NewSession(m), nil
+	}
+
+	s := &Session{}
+	if err := (securecookie.JSONEncoder{}).Deserialize(ms.data, s); err != nil {
+		return nil, err
+	}
+
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+
+	s.store = m
+
+	return s, nil
+}
+
+func (m *memoryStore) Save(w http.ResponseWriter, r *http.Request, s *Session) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// remove the previous session if the id was regenerated or the session destroyed
+	if cookie, err := r.Cookie(m.opts.name); err == nil {
+		var id string
+		if err := securecookie.DecodeMulti(m.opts.name, cookie.Value, &id, m.codecs...); err == nil && (id != s.ID || s.destroyed) {
+			delete(m.sessions, id)
+		}
+	}
+
+	if s.destroyed {
+		delete(m.sessions, s.ID)
+		http.SetCookie(w, m.opts.cookie(""))
+		return nil
+	}
+
+	data, err := (securecookie.JSONEncoder{}).Serialize(s)
+	if err != nil {
+		return err
+	}
+
+	m.sessions[s.ID] = &memorySession{
+		data:    data,
+		expires: time.Now().Add(m.opts.maxAge),
+	}
+
+	value, err := securecookie.EncodeMulti(m.opts.name, s.ID, m.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, m.opts.cookie(value))
+
+	return nil
+}
+
+// prune removes expired sessions, the lock must be held
+func (m *memoryStore) prune(now time.Time) {
+	for id, s := range m.sessions {
+		if now.After(s.expires) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.pruned = now
+}
+
+// SessionAuthorizer returns an Authorizer that loads the session into the context; if the session
+// has a principal it is added to the context, otherwise the request fails unless anonymous
+// sessions are allowed
+func SessionAuthorizer(store SessionStore, opts ...SessionAuthorizerOption) Authorizer {
+	a := &sessionAuthorizer{
+		store: store,
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a.authorize
+}
+
+func (a *sessionAuthorizer) authorize(r *http.Request) (context.Context, error) {
+	s, err := a.store.Load(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Principal == nil && !a.anonymous {
+		return nil, NewAuthError(http.StatusUnauthorized, "", ErrSessionMissing)
+	}
+
+	ctx := context.WithValue(r.Context(), contextKeySession, s)
+
+	if s.Principal != nil {
+		ctx = ContextWithPrincipal(ctx, s.Principal)
+	}
+
+	return ctx, nil
+}
+
+// RequestSession returns the session loaded by the SessionAuthorizer, or nil
+func RequestSession(ctx context.Context) *Session {
+	if s, ok := ctx.Value(contextKeySession).(*Session); ok {
+		return s
+	}
+	return nil
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// WithSessionAnonymous allows requests without an authenticated session, the session is still
+// loaded into the context
+func WithSessionAnonymous() SessionAuthorizerOption {
+	return func(a *sessionAuthorizer) {
+		a.anonymous = true
+	}
+}
+
+// WithSessionCookieName sets the session cookie name, the default is session
+func WithSessionCookieName(name string) SessionOption {
+	return func(o *sessionOptions) {
+		o.name = name
+	}
+}
+
+// WithSessionPath sets the session cookie path
+func WithSessionPath(path string) SessionOption {
+	return func(o *sessionOptions) {
+		o.path = path
+	}
+}
+
+// WithSessionDomain sets the session cookie domain
+func WithSessionDomain(domain string) SessionOption {
+	return func(o *sessionOptions) {
+		o.domain = domain
+	}
+}
+
+// WithSessionMaxAge sets the session lifetime, the default is 7 days
+func WithSessionMaxAge(d time.Duration) SessionOption {
+	return func(o *sessionOptions) {
+		o.maxAge = d
+	}
+}
+
+// WithSessionInsecure allows the session cookie over plain http, this should only be used in development
+func WithSessionInsecure() SessionOption {
+	return func(o *sessionOptions) {
+		o.secure = false
+	}
+}
+
+// WithSessionSameSite sets the session cookie SameSite mode, the default is lax
+func WithSessionSameSite(mode http.SameSite) SessionOption {
+	return func(o *sessionOptions) {
+		o.sameSite = mode
+	}
+}
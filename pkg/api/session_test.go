@@ -0,0 +1,166 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionStores(t *testing.T) {
+	oldKey := []byte("old-session-key")
+	newKey := []byte("new-session-key")
+
+	tests := []struct {
+		name  string
+		store func() (SessionStore, error)
+		// reload returns a store for the next request, i.e. after a key rotation
+		reload func() (SessionStore, error)
+	}{
+		{
+			name:  "signed cookie",
+			store: func() (SessionStore, error) { return NewSignedCookieStore([][]byte{oldKey}) },
+		},
+		{
+			name:  "signed cookie key rotation",
+			store: func() (SessionStore, error) { return NewSignedCookieStore([][]byte{oldKey}) },
+			reload: func() (SessionStore, error) {
+				return NewSignedCookieStore([][]byte{newKey, oldKey})
+			},
+		},
+		{
+			name: "encrypted cookie",
+			store: func() (SessionStore, error) {
+				return NewEncryptedCookieStore([]SessionKey{{Hash: oldKey, Block: []byte("0123456789abcdef")}})
+			},
+		},
+		{
+			name:  "memory",
+			store: func() (SessionStore, error) { return NewMemorySessionStore([][]byte{oldKey}) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := tt.store()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := store.Load(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.IsNew() {
+				t.Error("session without a cookie is not new")
+			}
+
+			s.Set("cart", "3 items")
+			s.Login(&Principal{Subject: "user"})
+
+			w := httptest.NewRecorder()
+			if err := store.Save(w, httptest.NewRequest("POST", "/login", nil), s); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.reload != nil {
+				if store, err = tt.reload(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			for _, c := range w.Result().Cookies() {
+				r.AddCookie(c)
+			}
+
+			loaded, err := store.Load(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if loaded.IsNew() || loaded.ID != s.ID || loaded.CSRFToken != s.CSRFToken {
+				t.Fatalf("loaded = %+v, want %+v", loaded, s)
+			}
+			if loaded.Principal == nil || loaded.Principal.Subject != "user" || loaded.Get("cart") != "3 items" {
+				t.Errorf("loaded principal %+v values %v", loaded.Principal, loaded.Values)
+			}
+
+			loaded.Destroy()
+
+			w = httptest.NewRecorder()
+			if err := store.Save(w, r, loaded); err != nil {
+				t.Fatal(err)
+			}
+
+			if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+				t.Errorf("cookies = %v, want an expired session cookie", c)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	s, err := NewMemorySessionStore([][]byte{[]byte("session-key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := s.(*memoryStore)
+
+	save := func(id string, expires time.Time) *http.Cookie {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		if err := m.Save(w, httptest.NewRequest("POST", "/", nil), &Session{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+		m.sessions[id].expires = expires
+
+		return w.Result().Cookies()[0]
+	}
+
+	load := func(c *http.Cookie) *Session {
+		t.Helper()
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(c)
+
+		s, err := m.Load(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	live := save("live", time.Now().Add(time.Hour))
+	expired := save("expired", time.Now().Add(-time.Second))
+
+	if s := load(live); s.ID != "live" {
+		t.Errorf("live session = %q", s.ID)
+	}
+
+	// the first load pruned the store, the expired session is only skipped until the next prune
+	m.sessions["expired"] = &memorySession{expires: time.Now().Add(-time.Second)}
+
+	if s := load(expired); !s.IsNew() {
+		t.Errorf("expired session %q was loaded", s.ID)
+	}
+	if _, ok := m.sessions["expired"]; !ok {
+		t.Error("store was pruned before the interval")
+	}
+
+	m.pruned = time.Now().Add(-memoryStorePruneInterval * 2)
+	load(live)
+
+	if _, ok := m.sessions["expired"]; ok {
+		t.Error("expired session was not pruned")
+	}
+}
@@ -0,0 +1,444 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// SignatureCanonicalizer builds the string to sign from the request components
+	SignatureCanonicalizer func(timestamp, method, uri string, body []byte) []byte
+
+	// SignatureOption defines request signature options, they apply to both the authorizer
+	// and the signer so both sides can share the same scheme
+	SignatureOption func(*signatureScheme)
+
+	// RequestSigner signs outgoing requests using the same scheme as the SignatureAuthorizer
+	RequestSigner struct {
+		scheme *signatureScheme
+		secret []byte
+	}
+
+	signatureScheme struct {
+		header          string
+		timestampHeader string
+		prefix          string
+		algorithm       string
+		hash            func() hash.Hash
+		base64          bool
+		canonical       SignatureCanonicalizer
+		window          time.Duration
+		maxBody         int64
+		realm           string
+		replay          bool
+		now             func() time.Time
+	}
+
+	signatureAuthorizer struct {
+		scheme  *signatureScheme
+		secrets map[string][]byte
+		names   []string
+		seen    map[string]time.Time
+		pruned  time.Time
+		lock    sync.Mutex
+	}
+
+	signerTransport struct {
+		signer *RequestSigner
+		base   http.RoundTripper
+	}
+)
+
+const (
+	defaultSignatureHeader          = "X-Signature"
+	defaultSignatureTimestampHeader = "X-Signature-Timestamp"
+	defaultSignatureWindow          = time.Minute * 5
+	defaultSignatureMaxBody         = 10 << 20
+)
+
+var (
+	// ErrSignatureMissing is returned when the request is not signed
+	ErrSignatureMissing = fmt.Errorf("%w: signature", ErrCredentialsMissing)
+
+	// ErrSignatureInvalid is returned when the signature does not match
+	ErrSignatureInvalid = errors.New("signature invalid")
+
+	// ErrSignatureExpired is returned when the signature timestamp is outside the window
+	ErrSignatureExpired = errors.New("signature timestamp outside of window")
+
+	// ErrSignatureReplayed is returned when a signature has already been used within the window
+	ErrSignatureReplayed = errors.New("signature replayed")
+
+	// ErrSignatureBodyTooLarge is returned when the body exceeds the maximum signed body size
+	ErrSignatureBodyTooLarge = errors.New("signed body too large")
+
+	signatureHashes = map[string]func() hash.Hash{
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+	}
+)
+
+func newSignatureScheme(opts ...SignatureOption) *signatureScheme {
+	s := &signatureScheme{
+		header:          defaultSignatureHeader,
+		timestampHeader: defaultSignatureTimestampHeader,
+		algorithm:       "sha256",
+		hash:            sha256.New,
+		canonical:       DefaultCanonicalizer,
+		window:          defaultSignatureWindow,
+		maxBody:         defaultSignatureMaxBody,
+		realm:           defaultAuthRealm,
+		now:             time.Now,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// DefaultCanonicalizer signs the timestamp, method, request uri and body separated by newlines
+func DefaultCanonicalizer(timestamp, method, uri string, body []byte) []byte {
+	buf := bytes.NewBufferString(strings.Join([]string{timestamp, method, uri, ""}, "\n"))
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// BodyCanonicalizer signs only the raw body, as used by many webhook providers
+func BodyCanonicalizer(_, _, _ string, body []byte) []byte {
+	return body
+}
+
+// sign returns the raw hmac of the canonical request
+func (s *signatureScheme) sign(secret []byte, timestamp, method, uri string, body []byte) []byte {
+	mac := hmac.New(s.hash, secret)
+	mac.Write(s.canonical(timestamp, method, uri, body))
+
+	return mac.Sum(nil)
+}
+
+// encode returns the encoded signature with the prefix
+func (s *signatureScheme) encode(sum []byte) string {
+	if s.base64 {
+		return s.prefix + base64.StdEncoding.EncodeToString(sum)
+	}
+
+	return s.prefix + hex.EncodeToString(sum)
+}
+
+// decode returns the raw signature from the header value, hex signatures are case-insensitive
+func (s *signatureScheme) decode(sig string) ([]byte, error) {
+	if !strings.HasPrefix(sig, s.prefix) {
+		return nil, ErrSignatureInvalid
+	}
+
+	sig = strings.TrimPrefix(sig, s.prefix)
+
+	if s.base64 {
+		return base64.StdEncoding.DecodeString(sig)
+	}
+
+	return hex.DecodeString(sig)
+}
+
+// readBody reads the request body and replaces it so it can be read again
+func (s *signatureScheme) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return []byte{}, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, s.maxBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > s.maxBody {
+		return nil, ErrSignatureBodyTooLarge
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	return data, nil
+}
+
+// SignatureAuthorizer returns an Authorizer that verifies an HMAC signature over the raw request;
+// the secrets map names to keys, all secrets are tried to allow rotation and the name of the
+// matching secret is the principal subject; the raw body remains available via RequestBody.
+// The signed uri is the request uri as received, before any path rewriting by the server.
+func SignatureAuthorizer(secrets map[string][]byte, opts ...SignatureOption) Authorizer {
+	a := &signatureAuthorizer{
+		scheme:  newSignatureScheme(opts...),
+		secrets: secrets,
+		names:   make([]string, 0, len(secrets)),
+		seen:    make(map[string]time.Time),
+	}
+
+	for name := range secrets {
+		a.names = append(a.names, name)
+	}
+
+	sort.Strings(a.names)
+
+	return a.authorize
+}
+
+func (a *signatureAuthorizer) authorize(r *http.Request) (context.Context, error) {
+	s := a.scheme
+
+	header := r.Header.Get(s.header)
+	if header == "" {
+		return nil, a.error(ErrSignatureMissing)
+	}
+
+	sig, err := s.decode(header)
+	if err != nil {
+		return nil, a.error(ErrSignatureInvalid)
+	}
+
+	var (
+		ts     string
+		signed time.Time
+	)
+
+	if s.timestampHeader != "" {
+		ts = r.Header.Get(s.timestampHeader)
+
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, a.error(ErrSignatureInvalid)
+		}
+
+		signed = time.Unix(sec, 0)
+
+		if d := s.now().Sub(signed); d > s.window || d < -s.window {
+			return nil, a.error(ErrSignatureExpired)
+		}
+	}
+
+	body, err := s.readBody(r)
+	if err != nil {
+		return nil, a.error(err)
+	}
+
+	// the server may rewrite the url path, i.e. for versioning, so the raw request uri is used
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	for _, name := range a.names {
+		expected := s.sign(a.secrets[name], ts, r.Method, uri, body)
+
+		if hmac.Equal(expected, sig) {
+			if s.replay && !signed.IsZero() && !a.use(sig, signed) {
+				return nil, a.error(ErrSignatureReplayed)
+			}
+
+			ctx := context.WithValue(r.Context(), contextKeyBody, body)
+
+			return ContextWithPrincipal(ctx, &Principal{
+				Subject: name,
+				Type:    "signature",
+			}), nil
+		}
+	}
+
+	return nil, a.error(ErrSignatureInvalid)
+}
+
+// use records the signature, returning false if it has already been seen; signatures are kept
+// until their timestamp is outside of the window and are pruned at most once per window
+func (a *signatureAuthorizer) use(sig []byte, signed time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := a.scheme.now()
+
+	if now.Sub(a.pruned) > a.scheme.window {
+		for key, expires := range a.seen {
+			if now.After(expires) {
+				delete(a.seen, key)
+			}
+		}
+		a.pruned = now
+	}
+
+	key := string(sig)
+
+	if _, ok := a.seen[key]; ok {
+		return false
+	}
+
+	a.seen[key] = signed.Add(a.scheme.window)
+
+	return true
+}
+
+func (a *signatureAuthorizer) error(err error) error {
+	return NewAuthError(
+		http.StatusUnauthorized,
+		authChallenge("Signature", map[string]string{
+			"realm":     a.scheme.realm,
+			"headers":   strings.TrimSpace(a.scheme.header + " " + a.scheme.timestampHeader),
+			"algorithm": "hmac-" + a.scheme.algorithm,
+		}),
+		err)
+}
+
+// NewRequestSigner returns a new request signer with the secret
+func NewRequestSigner(secret []byte, opts ...SignatureOption) *RequestSigner {
+	return &RequestSigner{
+		scheme: newSignatureScheme(opts...),
+		secret: secret,
+	}
+}
+
+// Sign signs the request, setting the signature and timestamp headers; the body is read and replaced
+func (rs *RequestSigner) Sign(r *http.Request) error {
+	s := rs.scheme
+
+	body, err := s.readBody(r)
+	if err != nil {
+		return err
+	}
+
+	var ts string
+
+	if s.timestampHeader != "" {
+		ts = strconv.FormatInt(s.now().Unix(), 10)
+		r.Header.Set(s.timestampHeader, ts)
+	}
+
+	r.Header.Set(s.header, s.encode(s.sign(rs.secret, ts, r.Method, r.URL.RequestURI(), body)))
+
+	return nil
+}
+
+// Transport returns an http.RoundTripper that signs each request before passing it to base,
+// http.DefaultTransport is used if base is nil
+func (rs *RequestSigner) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &signerTransport{
+		signer: rs,
+		base:   base,
+	}
+}
+
+func (t *signerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// round trippers must not modify the request
+	r = r.Clone(r.Context())
+
+	if err := t.signer.Sign(r); err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// WithSignatureHeader sets the signature header and optional value prefix, i.e. sha256=
+func WithSignatureHeader(header string, prefix ...string) SignatureOption {
+	return func(s *signatureScheme) {
+		s.header = header
+
+		if len(prefix) > 0 {
+			s.prefix = prefix[0]
+		}
+	}
+}
+
+// WithSignatureTimestampHeader sets the timestamp header, an empty header disables the timestamp
+// window check and replay protection
+func WithSignatureTimestampHeader(header string) SignatureOption {
+	return func(s *signatureScheme) {
+		s.timestampHeader = header
+	}
+}
+
+// WithSignatureAlgorithm sets the hmac hash algorithm, one of sha1, sha256 or sha512
+func WithSignatureAlgorithm(alg string) SignatureOption {
+	return func(s *signatureScheme) {
+		if h, ok := signatureHashes[alg]; ok {
+			s.algorithm = alg
+			s.hash = h
+		}
+	}
+}
+
+// WithSignatureBase64 encodes the signature as base64 instead of hex
+func WithSignatureBase64() SignatureOption {
+	return func(s *signatureScheme) {
+		s.base64 = true
+	}
+}
+
+// WithSignatureCanonicalizer sets the function used to build the string to sign
+func WithSignatureCanonicalizer(c SignatureCanonicalizer) SignatureOption {
+	return func(s *signatureScheme) {
+		if c != nil {
+			s.canonical = c
+		}
+	}
+}
+
+// WithSignatureWindow sets the timestamp window, requests with timestamps further than the window
+// from the current time are rejected; on its own this limits but does not prevent replays, see
+// WithSignatureReplayProtection
+func WithSignatureWindow(d time.Duration) SignatureOption {
+	return func(s *signatureScheme) {
+		s.window = d
+	}
+}
+
+// WithSignatureReplayProtection rejects signatures that have already been used within the window,
+// clients must then include a unique timestamp or nonce in each signed request; verified
+// signatures are held in memory so this only protects a single server instance
+func WithSignatureReplayProtection() SignatureOption {
+	return func(s *signatureScheme) {
+		s.replay = true
+	}
+}
+
+// WithSignatureMaxBody sets the maximum body size that will be read for verification
+func WithSignatureMaxBody(n int64) SignatureOption {
+	return func(s *signatureScheme) {
+		s.maxBody = n
+	}
+}
+
+// WithSignatureRealm sets the realm for the WWW-Authenticate challenge
+func WithSignatureRealm(realm string) SignatureOption {
+	return func(s *signatureScheme) {
+		s.realm = realm
+	}
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureAuthorizer(t *testing.T) {
+	secrets := map[string][]byte{
+		"current":  []byte("current-secret"),
+		"previous": []byte("previous-secret"),
+	}
+
+	tests := []struct {
+		name        string
+		opts        []SignatureOption
+		verifyOpts  []SignatureOption
+		secret      string
+		signedAt    time.Time
+		body        string
+		tamper      func(r *http.Request)
+		wantSubject string
+		wantErr     error
+	}{
+		{
+			name:        "valid",
+			secret:      "current-secret",
+			body:        `{"id":1}`,
+			wantSubject: "current",
+		},
+		{
+			name:        "rotated secret",
+			secret:      "previous-secret",
+			wantSubject: "previous",
+		},
+		{
+			name:   "path rewritten by the server",
+			secret: "current-secret",
+			tamper: func(r *http.Request) {
+				r.URL.Path = "/v2.0.0/items"
+			},
+			wantSubject: "current",
+		},
+		{
+			name:   "upper case hex",
+			secret: "current-secret",
+			tamper: func(r *http.Request) {
+				r.Header.Set(defaultSignatureHeader, strings.ToUpper(r.Header.Get(defaultSignatureHeader)))
+			},
+			wantSubject: "current",
+		},
+		{
+			name:        "base64 with prefix",
+			opts:        []SignatureOption{WithSignatureBase64(), WithSignatureHeader("X-Hub-Signature", "sha256=")},
+			secret:      "current-secret",
+			wantSubject: "current",
+		},
+		{
+			name:    "missing",
+			tamper:  func(r *http.Request) { r.Header.Del(defaultSignatureHeader) },
+			secret:  "current-secret",
+			wantErr: ErrSignatureMissing,
+		},
+		{
+			name:    "unknown secret",
+			secret:  "other",
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name:   "tampered body",
+			secret: "current-secret",
+			body:   `{"id":1}`,
+			tamper: func(r *http.Request) {
+				r.Body = ioutil.NopCloser(strings.NewReader(`{"id":2}`))
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name:   "tampered query",
+			secret: "current-secret",
+			tamper: func(r *http.Request) {
+				r.RequestURI += "&admin=true"
+			},
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name:     "expired",
+			secret:   "current-secret",
+			signedAt: time.Now().Add(-time.Hour),
+			wantErr:  ErrSignatureExpired,
+		},
+		{
+			name:       "body too large",
+			verifyOpts: []SignatureOption{WithSignatureMaxBody(4)},
+			secret:     "current-secret",
+			body:       `{"id":1}`,
+			wantErr:    ErrSignatureBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := NewRequestSigner([]byte(tt.secret), tt.opts...)
+			if !tt.signedAt.IsZero() {
+				signer.scheme.now = func() time.Time { return tt.signedAt }
+			}
+
+			// sign the request as the client sees it, then replay the headers on the server request
+			out, _ := http.NewRequest("POST", "https://api.example.com/v2/items?limit=10", strings.NewReader(tt.body))
+			if err := signer.Sign(out); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("POST", "/v2/items?limit=10", strings.NewReader(tt.body))
+			r.Header = out.Header
+
+			if tt.tamper != nil {
+				tt.tamper(r)
+			}
+
+			ctx, err := SignatureAuthorizer(secrets, append(tt.opts, tt.verifyOpts...)...)(r)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p := RequestPrincipal(ctx); p == nil || p.Subject != tt.wantSubject {
+				t.Errorf("principal = %+v, want %s", p, tt.wantSubject)
+			}
+
+			if got := string(RequestBody(ctx)); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestSignatureReplayProtection(t *testing.T) {
+	secrets := map[string][]byte{"client": []byte("secret")}
+	signer := NewRequestSigner(secrets["client"])
+
+	now := time.Now()
+
+	auth := SignatureAuthorizer(secrets, WithSignatureReplayProtection(), WithSignatureWindow(time.Minute))
+
+	request := func(path string, signedAt time.Time) *http.Request {
+		signer.scheme.now = func() time.Time { return signedAt }
+
+		r := httptest.NewRequest("GET", path, nil)
+		if err := signer.Sign(r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	first := request("/items", now)
+
+	steps := []struct {
+		name    string
+		req     *http.Request
+		wantErr error
+	}{
+		{
+			name: "first use",
+			req:  first,
+		},
+		{
+			name:    "replayed",
+			req:     first.Clone(context.Background()),
+			wantErr: ErrSignatureReplayed,
+		},
+		{
+			name: "new timestamp",
+			req:  request("/items", now.Add(time.Second)),
+		},
+		{
+			name: "different request",
+			req:  request("/other", now),
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			_, err := auth(step.req)
+			if step.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if step.wantErr != nil && !errors.Is(err, step.wantErr) {
+				t.Fatalf("error = %v, want %v", err, step.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureSeenPruned(t *testing.T) {
+	now := time.Now()
+
+	a := &signatureAuthorizer{
+		scheme: newSignatureScheme(WithSignatureReplayProtection(), WithSignatureWindow(time.Minute)),
+		seen:   make(map[string]time.Time),
+	}
+	a.scheme.now = func() time.Time { return now }
+
+	if !a.use([]byte("old"), now.Add(-time.Minute*2)) || !a.use([]byte("new"), now) {
+		t.Fatal("first use rejected")
+	}
+
+	// the window has passed since the last prune
+	now = now.Add(time.Minute * 2)
+
+	if !a.use([]byte("newer"), now) {
+		t.Fatal("first use rejected")
+	}
+
+	if _, ok := a.seen["old"]; ok {
+		t.Error("expired signature was not pruned")
+	}
+
+	if _, ok := a.seen["newer"]; !ok || len(a.seen) != 1 {
+		t.Errorf("seen = %v, want only the newer signature", a.seen)
+	}
+}
+
+func TestSignatureBodyReadable(t *testing.T) {
+	secrets := map[string][]byte{"client": []byte("secret")}
+	signer := NewRequestSigner(secrets["client"])
+
+	sig := SignatureAuthorizer(secrets)
+	other := testAuthorizer("other", nil)
+
+	tests := []struct {
+		name  string
+		route func(s *Server, h interface{})
+	}{
+		{
+			name: "signature only",
+			route: func(s *Server, h interface{}) {
+				s.AddRoute("/items", h, WithMethod(http.MethodPost), WithAuthorizers(sig))
+			},
+		},
+		{
+			name: "after another authorizer",
+			route: func(s *Server, h interface{}) {
+				s.AddRoute("/items", h, WithMethod(http.MethodPost), WithAuthorizers(other, sig))
+			},
+		},
+		{
+			name: "after a group authorizer",
+			route: func(s *Server, h interface{}) {
+				s.Group("/").Authorize(other).AddRoute("/items", h, WithMethod(http.MethodPost), WithAuthorizers(sig))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithBasepath("/"))
+
+			tt.route(s, func(w http.ResponseWriter, r *http.Request) Responder {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					return NewResponse(err.Error()).WithStatus(http.StatusInternalServerError)
+				}
+				return NewResponse(string(body) + "|" + string(RequestBody(r.Context())))
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("payload"))
+			if err := signer.Sign(r); err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+
+			if got := w.Body.String(); got != "payload|payload" {
+				t.Errorf("body = %q, want the body readable from the request and context", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+type (
+	limits struct {
+		readTimeout       time.Duration
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+		maxHeaderBytes    int
+		maxConns          int
+	}
+
+	// timeoutWriter buffers the handler response so it can be discarded on timeout
+	timeoutWriter struct {
+		w           http.ResponseWriter
+		h           http.Header
+		buf         bytes.Buffer
+		code        int
+		wroteHeader bool
+		timedOut    bool
+		lock        sync.Mutex
+	}
+)
+
+const (
+	defaultReadHeaderTimeout = time.Second * 10
+)
+
+var (
+	// ErrRouteTimeout is returned to the client when a route handler exceeds its deadline
+	ErrRouteTimeout = errors.New("request timeout")
+)
+
+// apply sets the limits on the http server
+func (l limits) apply(srv *http.Server) {
+	srv.ReadTimeout = l.readTimeout
+	srv.ReadHeaderTimeout = l.readHeaderTimeout
+	srv.WriteTimeout = l.writeTimeout
+	srv.IdleTimeout = l.idleTimeout
+	srv.MaxHeaderBytes = l.maxHeaderBytes
+}
+
+// listener limits the number of concurrent connections accepted by the listener
+func (l limits) listener(ln net.Listener) net.Listener {
+	if l.maxConns > 0 {
+		return netutil.LimitListener(ln, l.maxConns)
+	}
+	return ln
+}
+
+// timeoutHandler runs the handler with a deadline, the request context is canceled when the
+// deadline passes and an error is written with the status; the response is buffered so
+// streaming handlers should not use route timeouts
+func (s *Server) timeoutHandler(next http.Handler, timeout time.Duration, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		r = r.WithContext(ctx)
+
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
+
+		tw := &timeoutWriter{
+			w: w,
+			h: make(http.Header),
+		}
+
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicChan <- p
+				}
+			}()
+
+			next.ServeHTTP(tw, r)
+
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			panic(p)
+
+		case <-done:
+			tw.lock.Lock()
+			defer tw.lock.Unlock()
+
+			dst := w.Header()
+			for k, vv := range tw.h {
+				dst[k] = vv
+			}
+
+			if !tw.wroteHeader {
+				tw.code = http.StatusOK
+			}
+
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+
+		case <-ctx.Done():
+			tw.lock.Lock()
+			defer tw.lock.Unlock()
+
+			tw.timedOut = true
+
+			s.log.Warnf("%s %s: handler exceeded timeout of %s", r.Method, r.URL.EscapedPath(), timeout)
+
+			s.WriteError(w, status, ErrRouteTimeout)
+		}
+	})
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	tw.wroteHeader = true
+	tw.code = code
+}
+
+// WithReadTimeout sets the maximum duration for reading the entire request, including the body
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.limits.readTimeout = d
+	}
+}
+
+// WithReadHeaderTimeout sets the maximum duration for reading the request headers, the default is 10s
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.limits.readHeaderTimeout = d
+	}
+}
+
+// WithWriteTimeout sets the maximum duration before timing out writes of the response
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.limits.writeTimeout = d
+	}
+}
+
+// WithIdleTimeout sets the maximum amount of time to wait for the next request on keep-alive connections
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.limits.idleTimeout = d
+	}
+}
+
+// WithMaxHeaderBytes sets the maximum number of bytes the server will read parsing the request headers
+func WithMaxHeaderBytes(n int) Option {
+	return func(s *Server) {
+		s.limits.maxHeaderBytes = n
+	}
+}
+
+// WithMaxConnections limits the number of concurrent connections accepted by the listener,
+// additional connections wait in the accept backlog
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.limits.maxConns = n
+	}
+}
+
+// WithTimeout sets the route handler deadline, the request context is canceled when the deadline
+// passes and the optional status (default 503 Service Unavailable) is returned to the client
+func WithTimeout(d time.Duration, status ...int) RouteOption {
+	return func(r *routeOption) {
+		r.timeout = d
+		r.timeoutStatus = http.StatusServiceUnavailable
+
+		if len(status) > 0 {
+			r.timeoutStatus = status[0]
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    interface{}
+		opt        RouteOption
+		wantStatus int
+		wantBody   string
+		wantHeader string
+	}{
+		{
+			name: "within deadline",
+			handler: func(ctx context.Context) Responder {
+				return NewResponse("done").WithHeader("X-Handler", "done")
+			},
+			opt:        WithTimeout(time.Second),
+			wantStatus: http.StatusOK,
+			wantBody:   "done",
+			wantHeader: "done",
+		},
+		{
+			name: "status from handler",
+			handler: func(ctx context.Context) Responder {
+				return NewResponse("created").WithStatus(http.StatusCreated)
+			},
+			opt:        WithTimeout(time.Second),
+			wantStatus: http.StatusCreated,
+			wantBody:   "created",
+		},
+		{
+			name: "no response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Handler", "empty")
+			},
+			opt:        WithTimeout(time.Second),
+			wantStatus: http.StatusOK,
+			wantHeader: "empty",
+		},
+		{
+			name: "deadline exceeded",
+			handler: func(ctx context.Context) Responder {
+				<-ctx.Done()
+				return NewResponse("late").WithHeader("X-Handler", "late")
+			},
+			opt:        WithTimeout(20 * time.Millisecond),
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "deadline exceeded with status",
+			handler: func(ctx context.Context) Responder {
+				<-ctx.Done()
+				return NewResponse("late")
+			},
+			opt:        WithTimeout(20*time.Millisecond, http.StatusGatewayTimeout),
+			wantStatus: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithBasepath("/"), WithLog(&log.Logger{Handler: discard.Default}))
+
+			s.AddRoute("/slow", tt.handler, tt.opt)
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+
+			if got := w.Header().Get("X-Handler"); got != tt.wantHeader {
+				t.Errorf("X-Handler = %q, want %q", got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	s := NewServer(
+		WithReadTimeout(time.Second),
+		WithWriteTimeout(2*time.Second),
+		WithIdleTimeout(3*time.Second),
+		WithMaxHeaderBytes(4096),
+	)
+
+	srv := &http.Server{}
+	s.limits.apply(srv)
+
+	if srv.ReadTimeout != time.Second || srv.WriteTimeout != 2*time.Second || srv.IdleTimeout != 3*time.Second {
+		t.Errorf("timeouts = %s %s %s, want 1s 2s 3s", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+
+	if srv.ReadHeaderTimeout != defaultReadHeaderTimeout {
+		t.Errorf("read header timeout = %s, want %s", srv.ReadHeaderTimeout, defaultReadHeaderTimeout)
+	}
+
+	if srv.MaxHeaderBytes != 4096 {
+		t.Errorf("max header bytes = %d, want 4096", srv.MaxHeaderBytes)
+	}
+
+	l := newTestListener(t)
+	defer l.Close()
+
+	if s.limits.listener(l) != l {
+		t.Error("listener was limited without max connections")
+	}
+
+	WithMaxConnections(1)(s)
+
+	if s.limits.listener(l) == l {
+		t.Error("listener was not limited")
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// PeerIdentity is the verified client certificate identity of an mTLS connection
+	PeerIdentity struct {
+		Subject        pkix.Name
+		CommonName     string
+		DNSNames       []string
+		EmailAddresses []string
+		URIs           []*url.URL
+		Certificate    *x509.Certificate
+		Chains         [][]*x509.Certificate
+	}
+
+	tlsOptions struct {
+		certFile       string
+		keyFile        string
+		certs          []tls.Certificate
+		minVersion     uint16
+		cipherSuites   []uint16
+		clientAuth     tls.ClientAuthType
+		clientCAs      *x509.CertPool
+		clientCAFiles  []string
+		reloadInterval time.Duration
+	}
+
+	// certLoader loads a certificate pair from disk and reloads it when the files change
+	certLoader struct {
+		certFile       string
+		keyFile        string
+		reloadInterval time.Duration
+		cert           *tls.Certificate
+		certMod        time.Time
+		keyMod         time.Time
+		checked        time.Time
+		lock           sync.Mutex
+		log            func(string, ...interface{})
+	}
+)
+
+const (
+	defaultTLSMinVersion     = tls.VersionTLS12
+	defaultTLSReloadInterval = time.Minute
+)
+
+var (
+	contextKeyPeerIdentity = contextKey("peer-identity")
+)
+
+func (s *Server) useTLS() *tlsOptions {
+	if s.tls == nil {
+		s.tls = &tlsOptions{
+			minVersion:     defaultTLSMinVersion,
+			clientAuth:     tls.NoClientCert,
+			reloadInterval: defaultTLSReloadInterval,
+		}
+	}
+	return s.tls
+}
+
+// config builds the tls configuration, loading any certificates and client CAs from disk
+func (o *tlsOptions) config(s *Server) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:   o.minVersion,
+		CipherSuites: o.cipherSuites,
+		ClientAuth:   o.clientAuth,
+		Certificates: o.certs,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if o.certFile != "" || o.keyFile != "" {
+		loader := &certLoader{
+			certFile:       o.certFile,
+			keyFile:        o.keyFile,
+			reloadInterval: o.reloadInterval,
+			log:            s.log.Errorf,
+		}
+
+		if err := loader.load(); err != nil {
+			return nil, err
+		}
+
+		cfg.GetCertificate = loader.GetCertificate
+	}
+
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil {
+		return nil, errors.New("tls enabled without a certificate")
+	}
+
+	if o.clientCAs != nil || len(o.clientCAFiles) > 0 {
+		pool := o.clientCAs
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		for _, f := range o.clientCAFiles {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in %s", f)
+			}
+		}
+
+		cfg.ClientCAs = pool
+
+		if cfg.ClientAuth == tls.NoClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return cfg, nil
+}
+
+func (l *certLoader) load() error {
+	certInfo, err := os.Stat(l.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(l.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.cert = &cert
+	l.certMod = certInfo.ModTime()
+	l.keyMod = keyInfo.ModTime()
+	l.checked = time.Now()
+
+	return nil
+}
+
+// changed returns true if the certificate files have been modified since the last load
+func (l *certLoader) changed() bool {
+	l.lock.Lock()
+	if time.Since(l.checked) < l.reloadInterval {
+		l.lock.Unlock()
+		return false
+	}
+	l.checked = time.Now()
+	certMod, keyMod := l.certMod, l.keyMod
+	l.lock.Unlock()
+
+	certInfo, err := os.Stat(l.certFile)
+	if err != nil {
+		return false
+	}
+
+	keyInfo, err := os.Stat(l.keyFile)
+	if err != nil {
+		return false
+	}
+
+	return !certInfo.ModTime().Equal(certMod) || !keyInfo.ModTime().Equal(keyMod)
+}
+
+// GetCertificate implements the tls.Config GetCertificate callback, reloading the
+// certificate if the files have changed; on reload failure the previous certificate is used
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if l.reloadInterval > 0 && l.changed() {
+		if err := l.load(); err != nil {
+			l.log("tls certificate reload failed: %s", err)
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.cert, nil
+}
+
+func newPeerIdentity(state *tls.ConnectionState) *PeerIdentity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+
+	return &PeerIdentity{
+		Subject:        cert.Subject,
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           cert.URIs,
+		Certificate:    cert,
+		Chains:         state.VerifiedChains,
+	}
+}
+
+// RequestPeerIdentity returns the verified client certificate identity for the request,
+// or nil if the connection is not using a verified client certificate
+func RequestPeerIdentity(ctx context.Context) *PeerIdentity {
+	if p, ok := ctx.Value(contextKeyPeerIdentity).(*PeerIdentity); ok {
+		return p
+	}
+	return nil
+}
+
+func peerIdentityContext(r *http.Request) context.Context {
+	ctx := r.Context()
+
+	if p := newPeerIdentity(r.TLS); p != nil {
+		ctx = context.WithValue(ctx, contextKeyPeerIdentity, p)
+	}
+
+	return ctx
+}
+
+// WithTLSCertFiles enables tls using the certificate and key files, the files are checked for
+// changes and reloaded at most once per reload interval
+func WithTLSCertFiles(certFile, keyFile string) Option {
+	return func(s *Server) {
+		o := s.useTLS()
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// WithTLSCertificate enables tls using the in-memory certificates
+func WithTLSCertificate(certs ...tls.Certificate) Option {
+	return func(s *Server) {
+		o := s.useTLS()
+		o.certs = append(o.certs, certs...)
+	}
+}
+
+// WithTLSReloadInterval sets how often the certificate files are checked for changes, a zero
+// interval disables reloading
+func WithTLSReloadInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.useTLS().reloadInterval = d
+	}
+}
+
+// WithTLSMinVersion sets the minimum tls version, the default is tls 1.2
+func WithTLSMinVersion(v uint16) Option {
+	return func(s *Server) {
+		s.useTLS().minVersion = v
+	}
+}
+
+// WithTLSCipherSuites sets the allowed tls 1.2 cipher suites
+func WithTLSCipherSuites(suites ...uint16) Option {
+	return func(s *Server) {
+		s.useTLS().cipherSuites = suites
+	}
+}
+
+// WithClientCAs enables mutual tls, verifying client certificates against the pool and
+// optionally the PEM encoded CA files
+func WithClientCAs(pool *x509.CertPool, files ...string) Option {
+	return func(s *Server) {
+		o := s.useTLS()
+		o.clientCAs = pool
+		o.clientCAFiles = append(o.clientCAFiles, files...)
+	}
+}
+
+// WithClientAuth sets the client certificate policy, when client CAs are set the default
+// is tls.RequireAndVerifyClientCert
+func WithClientAuth(auth tls.ClientAuthType) Option {
+	return func(s *Server) {
+		s.useTLS().clientAuth = auth
+	}
+}
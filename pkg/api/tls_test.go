@@ -0,0 +1,367 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pool: pool,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM encoded certificate and key signed by the ca
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) keyPair(t *testing.T, cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, cn, usage)
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestTLSServe(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.keyPair(t, "server", x509.ExtKeyUsageServerAuth)
+	clientCert := ca.keyPair(t, "client", x509.ExtKeyUsageClientAuth)
+
+	otherCA := newTestCA(t)
+	untrustedCert := otherCA.keyPair(t, "untrusted", x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		clientCert *tls.Certificate
+		wantErr    bool
+		wantPeer   string
+	}{
+		{
+			name: "server certificate",
+			opts: []Option{WithTLSCertificate(serverCert)},
+		},
+		{
+			name:    "mtls without client certificate",
+			opts:    []Option{WithTLSCertificate(serverCert), WithClientCAs(ca.pool)},
+			wantErr: true,
+		},
+		{
+			name:       "mtls with untrusted client certificate",
+			opts:       []Option{WithTLSCertificate(serverCert), WithClientCAs(ca.pool)},
+			clientCert: &untrustedCert,
+			wantErr:    true,
+		},
+		{
+			name:       "mtls with client certificate",
+			opts:       []Option{WithTLSCertificate(serverCert), WithClientCAs(ca.pool)},
+			clientCert: &clientCert,
+			wantPeer:   "client",
+		},
+		{
+			name:     "optional client certificate",
+			opts:     []Option{WithTLSCertificate(serverCert), WithClientCAs(ca.pool), WithClientAuth(tls.VerifyClientCertIfGiven)},
+			wantPeer: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestListener(t)
+
+			s := NewServer(append(tt.opts, WithListener(l), WithBasepath("/"))...)
+
+			s.AddRoute("/peer", func(ctx context.Context) Responder {
+				if p := RequestPeerIdentity(ctx); p != nil {
+					return NewResponse(p.CommonName)
+				}
+				return NewResponse("")
+			})
+
+			if err := s.Serve(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Shutdown(context.Background())
+
+			cfg := &tls.Config{RootCAs: ca.pool}
+			if tt.clientCert != nil {
+				cfg.Certificates = []tls.Certificate{*tt.clientCert}
+			}
+
+			client := &http.Client{
+				Transport: &http.Transport{TLSClientConfig: cfg},
+				Timeout:   time.Second * 5,
+			}
+
+			resp, err := client.Get("https://" + l.Addr().String() + "/peer")
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected tls error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if got := string(body); got != tt.wantPeer {
+				t.Errorf("peer = %q, want %q", got, tt.wantPeer)
+			}
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{
+			name:    "no certificate",
+			opts:    []Option{WithTLSMinVersion(tls.VersionTLS13)},
+			wantErr: true,
+		},
+		{
+			name:    "missing files",
+			opts:    []Option{WithTLSCertFiles("missing.crt", "missing.key")},
+			wantErr: true,
+		},
+		{
+			name:    "missing client ca file",
+			opts:    []Option{WithTLSCertificate(tls.Certificate{}), WithClientCAs(nil, "missing.pem")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(tt.opts...)
+
+			_, err := s.tls.config(s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("config() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertLoaderReload(t *testing.T) {
+	ca := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "api-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	modTime := time.Now().Add(-time.Hour)
+
+	write := func(certPEM, keyPEM []byte) {
+		t.Helper()
+
+		if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		// modification times are advanced explicitly as some filesystems have coarse resolution
+		modTime = modTime.Add(time.Minute)
+
+		for _, f := range []string{certFile, keyFile} {
+			if err := os.Chtimes(f, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	commonName := func(l *certLoader) string {
+		t.Helper()
+
+		cert, err := l.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return leaf.Subject.CommonName
+	}
+
+	write(ca.issue(t, "first", x509.ExtKeyUsageServerAuth))
+
+	var logged []string
+
+	l := &certLoader{
+		certFile:       certFile,
+		keyFile:        keyFile,
+		reloadInterval: time.Nanosecond,
+		log: func(format string, args ...interface{}) {
+			logged = append(logged, format)
+		},
+	}
+
+	if err := l.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name       string
+		write      func()
+		wantCN     string
+		wantLogged int
+	}{
+		{
+			name:   "unchanged",
+			write:  func() {},
+			wantCN: "first",
+		},
+		{
+			name: "replaced",
+			write: func() {
+				write(ca.issue(t, "second", x509.ExtKeyUsageServerAuth))
+			},
+			wantCN: "second",
+		},
+		{
+			name: "invalid keeps previous",
+			write: func() {
+				write([]byte("invalid"), []byte("invalid"))
+			},
+			wantCN:     "second",
+			wantLogged: 1,
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.write()
+
+			// the reload interval is measured from the last check
+			time.Sleep(time.Millisecond)
+
+			if cn := commonName(l); cn != step.wantCN {
+				t.Errorf("certificate = %q, want %q", cn, step.wantCN)
+			}
+
+			if len(logged) != step.wantLogged {
+				t.Errorf("logged %d errors, want %d", len(logged), step.wantLogged)
+			}
+		})
+	}
+}
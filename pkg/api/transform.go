@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+
+	"github.com/blang/semver/v4"
+)
+
+type (
+	// VersionChange is a backwards incompatible change introduced in a version; handlers only deal
+	// with the latest shape and changes migrate requests from and responses to older versions.
+	// Only json request bodies and response payloads are migrated, query parameters and path
+	// variables are passed to the handler as sent and must be handled for all versions.
+	VersionChange struct {
+		// Version is the version that introduced the change
+		Version string
+
+		// Description describes the change for documentation
+		Description string
+
+		// Request migrates a json request body from the previous version to this version
+		Request VersionTransform
+
+		// Response migrates a json response payload from this version to the previous version
+		Response VersionTransform
+	}
+
+	// VersionTransform transforms a decoded json payload, it may modify and return data or return
+	// a new value; numbers are decoded as json.Number so large integers are not rounded
+	VersionTransform func(ctx context.Context, data interface{}) (interface{}, error)
+
+	// versionPipeline is the ordered list of changes for a route
+	versionPipeline []versionChange
+
+	versionChange struct {
+		VersionChange
+		version semver.Version
+	}
+)
+
+var (
+	// ErrInvalidJSON is returned when a json body has data after the json value
+	ErrInvalidJSON = errors.New("invalid json: unexpected data after value")
+)
+
+func newVersionPipeline(changes []VersionChange) versionPipeline {
+	p := make(versionPipeline, 0, len(changes))
+
+	for _, c := range changes {
+		p = append(p, versionChange{
+			VersionChange: c,
+			version:       mustParseRouteVersion(c.Version),
+		})
+	}
+
+	sort.SliceStable(p, func(i, j int) bool {
+		return p[i].version.LT(p[j].version)
+	})
+
+	return p
+}
+
+// request migrates the request json body up from the requested version, the body is replaced
+// with the transformed body
+func (p versionPipeline) request(r *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	if t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); t != "application/json" {
+		return nil
+	}
+
+	ctx := r.Context()
+	v := Version(ctx)
+
+	var changes []versionChange
+
+	for _, c := range p {
+		if c.Request != nil && c.version.GT(v) {
+			changes = append(changes, c)
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if len(data) > 0 {
+		payload, err := decodeJSON(data)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			if payload, err = c.Request(ctx, payload); err != nil {
+				return err
+			}
+		}
+
+		if data, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+
+	return nil
+}
+
+// response migrates a successful json response payload down to the requested version
+func (p versionPipeline) response(ctx context.Context, resp *Response) error {
+	if resp.status >= http.StatusBadRequest || resp.payload == nil || resp.header.Get("Content-Type") == "application/xml" {
+		return nil
+	}
+
+	switch resp.payload.(type) {
+	case []byte, string, Encoder, io.Reader:
+		return nil
+	}
+
+	v := Version(ctx)
+
+	var changes []versionChange
+
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].Response != nil && p[i].version.GT(v) {
+			changes = append(changes, p[i])
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(resp.payload)
+	if err != nil {
+		return err
+	}
+
+	payload, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		if payload, err = c.Response(ctx, payload); err != nil {
+			return err
+		}
+	}
+
+	resp.payload = payload
+
+	return nil
+}
+
+// decodeJSON decodes the payload with numbers as json.Number
+func decodeJSON(data []byte) (interface{}, error) {
+	var payload interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, ErrInvalidJSON
+	}
+
+	return payload, nil
+}
+
+// WithVersionChanges adds version changes to the route, json request bodies are migrated to the
+// latest version before the handler is called and successful json responses are migrated back
+// to the requested version; an invalid change version panics when the route is added
+func WithVersionChanges(changes ...VersionChange) RouteOption {
+	return func(r *routeOption) {
+		r.changes = append(r.changes, changes...)
+	}
+}
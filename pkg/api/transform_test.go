@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver/v4"
+)
+
+// renameField returns a transform that renames a top level object field
+func renameField(from, to string) VersionTransform {
+	return func(_ context.Context, data interface{}) (interface{}, error) {
+		if m, ok := data.(map[string]interface{}); ok {
+			if v, ok := m[from]; ok {
+				m[to] = v
+				delete(m, from)
+			}
+		}
+		return data, nil
+	}
+}
+
+func TestVersionPipelineRequest(t *testing.T) {
+	p := newVersionPipeline([]VersionChange{
+		{Version: "3.0.0", Request: renameField("name", "full_name")},
+		{Version: "2.0.0", Request: renameField("user", "name")},
+	})
+
+	tests := []struct {
+		name        string
+		version     string
+		contentType string
+		body        string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "migrated through each change in order",
+			version:     "1.0.0",
+			contentType: "application/json",
+			body:        `{"user":"ada","id":9007199254740993}`,
+			want:        `{"full_name":"ada","id":9007199254740993}`,
+		},
+		{
+			name:        "only later changes apply",
+			version:     "2.0.0",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"user":"ada","name":"lovelace"}`,
+			want:        `{"full_name":"lovelace","user":"ada"}`,
+		},
+		{
+			name:        "latest version is unchanged",
+			version:     "3.0.0",
+			contentType: "application/json",
+			body:        `{"user":"ada"}`,
+			want:        `{"user":"ada"}`,
+		},
+		{
+			name:        "other content types are unchanged",
+			version:     "1.0.0",
+			contentType: "text/plain",
+			body:        `{"user":"ada"}`,
+			want:        `{"user":"ada"}`,
+		},
+		{
+			name:        "trailing data",
+			version:     "1.0.0",
+			contentType: "application/json",
+			body:        `{"user":"ada"} {}`,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid json",
+			version:     "1.0.0",
+			contentType: "application/json",
+			body:        `{"user":`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyVersion, semver.MustParse(tt.version)))
+
+			err := p.request(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("request() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			body, _ := ioutil.ReadAll(r.Body)
+
+			if string(body) != tt.want {
+				t.Errorf("body = %s, want %s", body, tt.want)
+			}
+
+			if r.ContentLength != int64(len(body)) {
+				t.Errorf("content length = %d, want %d", r.ContentLength, len(body))
+			}
+		})
+	}
+}
+
+func TestVersionPipelineResponse(t *testing.T) {
+	type user struct {
+		ID       int64  `json:"id"`
+		FullName string `json:"full_name"`
+	}
+
+	p := newVersionPipeline([]VersionChange{
+		{Version: "2.0.0", Response: renameField("name", "user")},
+		{Version: "3.0.0", Response: renameField("full_name", "name")},
+	})
+
+	tests := []struct {
+		name    string
+		version string
+		resp    *Response
+		want    string
+	}{
+		{
+			name:    "migrated back through each change",
+			version: "1.0.0",
+			resp:    NewResponse(user{ID: 9007199254740993, FullName: "ada"}),
+			want:    `{"id":9007199254740993,"user":"ada"}`,
+		},
+		{
+			name:    "latest version is unchanged",
+			version: "3.0.0",
+			resp:    NewResponse(user{ID: 1, FullName: "ada"}),
+			want:    `{"id":1,"full_name":"ada"}`,
+		},
+		{
+			name:    "errors are unchanged",
+			version: "1.0.0",
+			resp:    NewResponse(map[string]string{"name": "invalid"}).WithStatus(400),
+			want:    `{"name":"invalid"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), contextKeyVersion, semver.MustParse(tt.version))
+
+			if err := p.response(ctx, tt.resp); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := json.Marshal(tt.resp.Payload())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(data) != tt.want {
+				t.Errorf("payload = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
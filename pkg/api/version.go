@@ -9,61 +9,245 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strings"
 
 	"github.com/blang/semver/v4"
-	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 )
 
-type key int
+type (
+	// VersionStrategy selects the requested api version from the request
+	VersionStrategy interface {
+		// Version returns the requested version and true if the request specifies one
+		Version(r *http.Request) (string, bool)
+	}
+
+	// VersionStrategyFunc is a function VersionStrategy
+	VersionStrategyFunc func(r *http.Request) (string, bool)
+
+	pathVersion string
+
+	headerVersion []string
+
+	mediaTypeVersion string
+
+	queryVersion string
+)
+
+var (
+	// ErrInvalidVersion is returned when the requested version can not be parsed
+	ErrInvalidVersion = errors.New("invalid api version")
+
+	// ErrUnsupportedVersion is returned when the requested version is newer than the api version
+	ErrUnsupportedVersion = errors.New("unsupported api version")
 
-const verKey key = 0
+	contextKeyVersion = contextKey("version")
+
+	contextKeyRequestVersion = contextKey("request-version")
+)
+
+// resolveVersion returns the requested version from the first strategy that finds one, or the
+// server version if none do
+func (s *Server) resolveVersion(r *http.Request) (string, semver.Version, error) {
+	for _, st := range s.versionStrategies {
+		if ver, ok := st.Version(r); ok {
+			v, err := semver.ParseTolerant(ver)
+			return ver, v, err
+		}
+	}
+
+	v, err := semver.ParseTolerant(s.version)
+
+	return s.version, v, err
+}
 
 func (s *Server) versionMiddleware() func(http.Handler) http.Handler {
 	apiVer, _ := semver.ParseTolerant(s.version)
 
+	var vary []string
+
+	for _, st := range s.versionStrategies {
+		if v, ok := st.(interface{ Vary() []string }); ok {
+			vary = append(vary, v.Vary()...)
+		}
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			vars := mux.Vars(r)
-
-			ver, ok := vars["version"]
-			if !ok {
-				w.WriteHeader(http.StatusNotFound)
-				return
+			for _, h := range vary {
+				w.Header().Add("Vary", h)
 			}
 
-			pathVer, err := semver.ParseTolerant(ver)
+			ver, reqVer, err := s.resolveVersion(r)
 			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
+				s.WriteError(w, http.StatusBadRequest, ErrInvalidVersion)
 				return
 			}
 
-			if pathVer.GT(apiVer) {
-				w.WriteHeader(http.StatusNotFound)
+			if reqVer.GT(apiVer) {
+				s.WriteError(w, http.StatusNotFound, ErrUnsupportedVersion)
 				return
 			}
 
-			r.URL.Path = strings.Replace(r.URL.Path, ver, pathVer.String(), 1)
+			if pv, ok := mux.Vars(r)["version"]; ok && pv == ver {
+				r.URL.Path = strings.Replace(r.URL.Path, ver, reqVer.String(), 1)
+			}
+
+			ctx := context.WithValue(r.Context(), contextKeyVersion, reqVer)
+			ctx = context.WithValue(ctx, contextKeyRequestVersion, ver)
 
-			context.Set(r, verKey, ver)
+			r = r.WithContext(ctx)
 
 			w.Header().Set("Server", fmt.Sprintf("%s/%s", s.name, s.serverVersion))
+			w.Header().Set("API-Version", reqVer.String())
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// RequestVersion returns the request version or the server version is not found
+// RequestVersion returns the request version as sent by the client, i.e. v1, or the server
+// version is not found
+//
+// Deprecated: use Version with the request context for the parsed version
 func (s *Server) RequestVersion(r *http.Request) string {
-	ver := s.version
+	if v, ok := r.Context().Value(contextKeyRequestVersion).(string); ok {
+		return v
+	}
+
+	return s.version
+}
+
+// Version returns the negotiated api version for the request, or the zero version if
+// the request was not routed by the server
+func Version(ctx context.Context) semver.Version {
+	if v, ok := ctx.Value(contextKeyVersion).(semver.Version); ok {
+		return v
+	}
+	return semver.Version{}
+}
+
+// VersionAtLeast returns true if the request version is the version or later, an invalid
+// version returns false
+func VersionAtLeast(ctx context.Context, version string) bool {
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return false
+	}
+	return Version(ctx).GTE(v)
+}
+
+// VersionBefore returns true if the request version is earlier than the version, an invalid
+// version returns false
+func VersionBefore(ctx context.Context, version string) bool {
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return false
+	}
+	return Version(ctx).LT(v)
+}
 
-	if val, ok := context.GetOk(r, verKey); ok {
-		ver = val.(string)
+// VersionInRange returns true if the request version is in the semver range, i.e. ">=1.2.0 <2.0.0";
+// an invalid range returns false
+func VersionInRange(ctx context.Context, versions string) bool {
+	r, err := semver.ParseRange(versions)
+	if err != nil {
+		return false
 	}
+	return r(Version(ctx))
+}
+
+// requestSemver returns the requested version, the server version is used if versioning is disabled
+func (s *Server) requestSemver(r *http.Request) semver.Version {
+	if v, ok := r.Context().Value(contextKeyVersion).(semver.Version); ok {
+		return v
+	}
+
+	v, _ := semver.ParseTolerant(s.version)
+
+	return v
+}
+
+// Version implements the VersionStrategy interface
+func (f VersionStrategyFunc) Version(r *http.Request) (string, bool) {
+	return f(r)
+}
+
+// PathVersion selects the version from the path variable, this is the default strategy
+func PathVersion(name string) VersionStrategy {
+	return pathVersion(name)
+}
+
+func (p pathVersion) Version(r *http.Request) (string, bool) {
+	ver, ok := mux.Vars(r)[string(p)]
+	return ver, ok
+}
+
+// HeaderVersion selects the version from the first header present, the default headers are
+// Accept-Version and API-Version
+func HeaderVersion(headers ...string) VersionStrategy {
+	if len(headers) == 0 {
+		headers = []string{"Accept-Version", "API-Version"}
+	}
+	return headerVersion(headers)
+}
+
+func (h headerVersion) Version(r *http.Request) (string, bool) {
+	for _, name := range h {
+		if ver := strings.TrimSpace(r.Header.Get(name)); ver != "" {
+			return ver, true
+		}
+	}
+	return "", false
+}
+
+func (h headerVersion) Vary() []string {
+	return h
+}
+
+// MediaTypeVersion selects the version from a media type parameter in the Accept header, i.e.
+// application/vnd.atomic+json; version=2; the default parameter is version
+func MediaTypeVersion(param ...string) VersionStrategy {
+	if len(param) > 0 {
+		return mediaTypeVersion(param[0])
+	}
+	return mediaTypeVersion("version")
+}
+
+func (m mediaTypeVersion) Version(r *http.Request) (string, bool) {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mt := range splitQuoted(accept, ',') {
+			_, params, err := mime.ParseMediaType(strings.TrimSpace(mt))
+			if err != nil {
+				continue
+			}
+
+			if ver, ok := params[string(m)]; ok && ver != "" {
+				return ver, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (m mediaTypeVersion) Vary() []string {
+	return []string{"Accept"}
+}
+
+// QueryVersion selects the version from the query parameter, the default parameter is version
+func QueryVersion(param ...string) VersionStrategy {
+	if len(param) > 0 {
+		return queryVersion(param[0])
+	}
+	return queryVersion("version")
+}
 
-	return ver
+func (q queryVersion) Version(r *http.Request) (string, bool) {
+	ver := r.URL.Query().Get(string(q))
+	return ver, ver != ""
 }
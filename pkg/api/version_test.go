@@ -0,0 +1,257 @@
+/*
+ * Copyright (C) 2020 Atomic Media Foundation
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license.  See the LICENSE file in the root of this
+ * workspace for details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver/v4"
+)
+
+func TestWithVersioning(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []Option
+		wantServer     string
+		wantStrategies int
+	}{
+		{
+			name:           "version only",
+			opts:           []Option{WithVersioning("2.0.0")},
+			wantServer:     "2.0.0",
+			wantStrategies: 1,
+		},
+		{
+			name:           "server version",
+			opts:           []Option{WithVersioning("2.0.0", "2.1.0")},
+			wantServer:     "2.1.0",
+			wantStrategies: 1,
+		},
+		{
+			name:           "strategies",
+			opts:           []Option{WithVersioning("2.0.0", "2.1.0"), WithVersionStrategy(HeaderVersion(), QueryVersion())},
+			wantServer:     "2.1.0",
+			wantStrategies: 2,
+		},
+		{
+			name:           "strategies before versioning",
+			opts:           []Option{WithVersionStrategy(HeaderVersion()), WithVersioning("2.0.0")},
+			wantServer:     "2.0.0",
+			wantStrategies: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(tt.opts...)
+
+			if !s.versioning || s.version != "2.0.0" {
+				t.Errorf("versioning = %v %s, want 2.0.0", s.versioning, s.version)
+			}
+
+			if s.serverVersion != tt.wantServer {
+				t.Errorf("server version = %s, want %s", s.serverVersion, tt.wantServer)
+			}
+
+			if len(s.versionStrategies) != tt.wantStrategies {
+				t.Errorf("strategies = %d, want %d", len(s.versionStrategies), tt.wantStrategies)
+			}
+		})
+	}
+}
+
+func TestVersionStrategies(t *testing.T) {
+	s := NewServer(
+		WithVersioning("2.0.0", "2.0.1"),
+		WithVersionStrategy(HeaderVersion(), MediaTypeVersion(), QueryVersion(), PathVersion("version")),
+	)
+
+	s.AddRoute("/version", func(ctx context.Context) Responder {
+		return NewResponse(Version(ctx).String())
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		headers    map[string]string
+		wantStatus int
+		wantBody   string
+		wantErr    error
+	}{
+		{
+			name:       "path",
+			path:       "/api/1.0.0/version",
+			wantStatus: 200,
+			wantBody:   "1.0.0",
+		},
+		{
+			name:       "tolerant path",
+			path:       "/api/v1.2/version",
+			wantStatus: 200,
+			wantBody:   "1.2.0",
+		},
+		{
+			name:       "header before path",
+			path:       "/api/1.0.0/version",
+			headers:    map[string]string{"Accept-Version": "1.5.0"},
+			wantStatus: 200,
+			wantBody:   "1.5.0",
+		},
+		{
+			name:       "media type",
+			path:       "/api/1.0.0/version",
+			headers:    map[string]string{"Accept": "application/json; version=1.1"},
+			wantStatus: 200,
+			wantBody:   "1.1.0",
+		},
+		{
+			name:       "newer than the server",
+			path:       "/api/3.0.0/version",
+			wantStatus: 404,
+			wantErr:    ErrUnsupportedVersion,
+		},
+		{
+			name:       "header newer than the server",
+			path:       "/api/1.0.0/version",
+			headers:    map[string]string{"Accept-Version": "3"},
+			wantStatus: 404,
+			wantErr:    ErrUnsupportedVersion,
+		},
+		{
+			name:       "invalid path version",
+			path:       "/api/latest/version",
+			wantStatus: 400,
+			wantErr:    ErrInvalidVersion,
+		},
+		{
+			name:       "invalid header version",
+			path:       "/api/1.0.0/version",
+			headers:    map[string]string{"Accept-Version": "latest"},
+			wantStatus: 400,
+			wantErr:    ErrInvalidVersion,
+		},
+		{
+			name:       "invalid query version",
+			path:       "/api/1.0.0/version?version=next",
+			wantStatus: 400,
+			wantErr:    ErrInvalidVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantErr != nil {
+				if !strings.Contains(w.Body.String(), tt.wantErr.Error()) {
+					t.Errorf("body = %s, want %v", w.Body.String(), tt.wantErr)
+				}
+				return
+			}
+
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("version = %s, want %s", got, tt.wantBody)
+			}
+
+			if got := w.Header().Get("API-Version"); got != tt.wantBody {
+				t.Errorf("API-Version = %s, want %s", got, tt.wantBody)
+			}
+
+			if got := w.Header().Values("Vary"); len(got) != 3 {
+				t.Errorf("Vary = %v, want the version headers and Accept", got)
+			}
+		})
+	}
+}
+
+func TestVersionComparisons(t *testing.T) {
+	ctx := context.WithValue(context.Background(), contextKeyVersion, semver.MustParse("1.5.0"))
+
+	tests := []struct {
+		name  string
+		check func(context.Context) bool
+		want  bool
+	}{
+		{"at least earlier", func(ctx context.Context) bool { return VersionAtLeast(ctx, "1.2") }, true},
+		{"at least same", func(ctx context.Context) bool { return VersionAtLeast(ctx, "v1.5.0") }, true},
+		{"at least later", func(ctx context.Context) bool { return VersionAtLeast(ctx, "2") }, false},
+		{"at least invalid", func(ctx context.Context) bool { return VersionAtLeast(ctx, "latest") }, false},
+		{"before later", func(ctx context.Context) bool { return VersionBefore(ctx, "2.0.0") }, true},
+		{"before same", func(ctx context.Context) bool { return VersionBefore(ctx, "1.5.0") }, false},
+		{"before invalid", func(ctx context.Context) bool { return VersionBefore(ctx, "") }, false},
+		{"in range", func(ctx context.Context) bool { return VersionInRange(ctx, ">=1.2.0 <2.0.0") }, true},
+		{"out of range", func(ctx context.Context) bool { return VersionInRange(ctx, ">=2.0.0") }, false},
+		{"invalid range", func(ctx context.Context) bool { return VersionInRange(ctx, "~>") }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.check(ctx); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if v := Version(context.Background()); !v.Equals(semver.Version{}) {
+		t.Errorf("Version() without a request = %s, want the zero version", v)
+	}
+}
+
+func TestRequestVersion(t *testing.T) {
+	s := NewServer(WithVersioning("2.0.0"), WithVersionStrategy(HeaderVersion(), PathVersion("version")))
+
+	s.AddRoute("/version", func(w http.ResponseWriter, r *http.Request) Responder {
+		return NewResponse(s.RequestVersion(r) + "|" + Version(r.Context()).String())
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   string
+	}{
+		{"path", "/api/v1/version", "", "v1|1.0.0"},
+		{"full path", "/api/1.2.0/version", "", "1.2.0|1.2.0"},
+		{"header", "/api/v1/version", "1.5", "1.5|1.5.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set("Accept-Version", tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("versions = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := s.RequestVersion(httptest.NewRequest("GET", "/", nil)); got != "2.0.0" {
+		t.Errorf("RequestVersion() outside of the api = %s, want the server version", got)
+	}
+}